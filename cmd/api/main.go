@@ -4,21 +4,57 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/halva/songswap/internal/database"
 	"github.com/halva/songswap/internal/handlers"
+	"github.com/halva/songswap/internal/keyring"
+	"github.com/halva/songswap/internal/mail"
 	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/moderation"
+	"github.com/halva/songswap/internal/oauth"
+	"github.com/halva/songswap/internal/search"
 	"github.com/joho/godotenv"
 )
 
 func main() {
 	godotenv.Load()
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Fatal("JWT_SECRET environment variable is required")
+	// JWT_KEYS_FILE enables key rotation; otherwise JWT_SECRET is the only key
+	if keysFile := os.Getenv("JWT_KEYS_FILE"); keysFile != "" {
+		keys, err := keyring.LoadFile(keysFile)
+		if err != nil {
+			log.Fatal("Failed to load JWT keys:", err)
+		}
+		handlers.SetKeyring(keys)
+	} else {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			log.Fatal("JWT_SECRET or JWT_KEYS_FILE environment variable is required")
+		}
+		handlers.SetKeyring(keyring.NewHMAC("default", []byte(secret)))
 	}
-	handlers.SetJwtSecret([]byte(secret))
+	middleware.SetSessionChecker(handlers.SessionActive)
+	middleware.SetAPITokenLookup(handlers.LookupAPIToken)
+	handlers.SetOAuthStateSecret([]byte(os.Getenv("OAUTH_STATE_SECRET")))
+
+	providers, err := oauth.FromEnv(nil)
+	if err != nil {
+		log.Fatal("Failed to configure OAuth providers:", err)
+	}
+	handlers.SetProviders(providers)
+
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure mailer:", err)
+	}
+	handlers.SetMailer(mailer)
+
+	moderator, err := moderation.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure comment moderation:", err)
+	}
+	handlers.SetModerator(moderator)
 
 	port := "8080"
 
@@ -26,31 +62,99 @@ func main() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	log.Println("Connected to database")
+	handlers.SetSearchStore(search.NewPostgres(database.DB))
+	handlers.StartTrendingJob(10 * time.Minute)
 
 	apiLimiter := middleware.NewRateLimiter(10, 20)
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /health", handlers.Health)
+	mux.HandleFunc("GET /.well-known/jwks.json", handlers.JWKS)
 	mux.HandleFunc("POST /register", handlers.Register)
 	mux.HandleFunc("POST /login", handlers.Login)
-	mux.HandleFunc("POST /songs", middleware.AuthMiddleware(handlers.JwtSecret, handlers.SubmitSong))
-	mux.HandleFunc("GET /discover", middleware.AuthMiddleware(handlers.JwtSecret, handlers.Discover))
-	mux.HandleFunc("POST /songs/{id}/like", middleware.AuthMiddleware(handlers.JwtSecret, handlers.LikeSong))
-	mux.HandleFunc("GET /history", middleware.AuthMiddleware(handlers.JwtSecret, handlers.History))
-	mux.HandleFunc("DELETE /songs/{id}/like", middleware.AuthMiddleware(handlers.JwtSecret, handlers.UnlikeSong))
+	mux.HandleFunc("POST /auth/exchange", handlers.Exchange)
+	mux.HandleFunc("POST /auth/refresh", handlers.Refresh)
+	mux.HandleFunc("POST /auth/logout", handlers.Logout)
+	mux.HandleFunc("POST /auth/logout-all", middleware.AuthMiddleware(handlers.Keys, handlers.LogoutAll))
+	mux.HandleFunc("POST /me/username", middleware.AuthMiddleware(handlers.Keys, handlers.ChooseUsername))
+	mux.HandleFunc("POST /me/password", middleware.AuthMiddleware(handlers.Keys, handlers.ChangePassword))
+	mux.HandleFunc("GET /me/email", middleware.AuthMiddleware(handlers.Keys, handlers.GetEmail))
+	mux.HandleFunc("POST /me/email", middleware.AuthMiddleware(handlers.Keys, handlers.SetEmail))
+	mux.HandleFunc("POST /auth/verify-email", handlers.VerifyEmail)
+	mux.HandleFunc("POST /auth/forgot", handlers.ForgotPassword)
+	mux.HandleFunc("POST /auth/reset", handlers.ResetPassword)
+	mux.HandleFunc("POST /auth/2fa", handlers.VerifyMFA)
+	mux.HandleFunc("GET /me/2fa", middleware.AuthMiddleware(handlers.Keys, handlers.TwoFactorStatus))
+	mux.HandleFunc("POST /me/2fa/setup", middleware.AuthMiddleware(handlers.Keys, handlers.SetupTOTP))
+	mux.HandleFunc("POST /me/2fa/enable", middleware.AuthMiddleware(handlers.Keys, handlers.EnableTOTP))
+	mux.HandleFunc("POST /me/2fa/disable", middleware.AuthMiddleware(handlers.Keys, handlers.DisableTOTP))
+	mux.HandleFunc("POST /me/2fa/recovery-codes", middleware.AuthMiddleware(handlers.Keys, handlers.RegenerateRecoveryCodes))
+	mux.HandleFunc("GET /me/sessions", middleware.AuthMiddleware(handlers.Keys, handlers.ListSessions))
+	mux.HandleFunc("DELETE /me/sessions/{id}", middleware.AuthMiddleware(handlers.Keys, handlers.RevokeSession))
+	mux.HandleFunc("PATCH /me", middleware.AuthMiddleware(handlers.Keys, handlers.UpdateProfile))
+	mux.HandleFunc("GET /me/export", middleware.AuthMiddleware(handlers.Keys, handlers.ExportAccount))
+	mux.HandleFunc("DELETE /me", middleware.AuthMiddleware(handlers.Keys, handlers.DeleteAccount))
+	mux.HandleFunc("GET /me/tokens", middleware.AuthMiddleware(handlers.Keys, handlers.ListAPITokens))
+	mux.HandleFunc("POST /me/tokens", middleware.AuthMiddleware(handlers.Keys, handlers.CreateAPIToken))
+	mux.HandleFunc("DELETE /me/tokens/{id}", middleware.AuthMiddleware(handlers.Keys, handlers.RevokeAPIToken))
+	mux.HandleFunc("POST /songs", middleware.AuthMiddleware(handlers.Keys, handlers.SubmitSong))
+	mux.HandleFunc("GET /discover", middleware.AuthMiddleware(handlers.Keys, handlers.Discover))
+	mux.HandleFunc("POST /songs/{id}/like", middleware.AuthMiddleware(handlers.Keys, handlers.LikeSong))
+	mux.HandleFunc("GET /history", middleware.AuthMiddleware(handlers.Keys, handlers.History))
+	mux.HandleFunc("DELETE /songs/{id}/like", middleware.AuthMiddleware(handlers.Keys, handlers.UnlikeSong))
+	// Reactions and comments, for people who discovered the song
+	mux.HandleFunc("GET /songs/{id}/reactions", middleware.AuthMiddleware(handlers.Keys, handlers.GetReactions))
+	mux.HandleFunc("POST /songs/{id}/reactions", middleware.AuthMiddleware(handlers.Keys, handlers.AddReaction))
+	mux.HandleFunc("DELETE /songs/{id}/reactions/{emoji}", middleware.AuthMiddleware(handlers.Keys, handlers.RemoveReaction))
+	mux.HandleFunc("GET /songs/{id}/comments", middleware.AuthMiddleware(handlers.Keys, handlers.ListComments))
+	mux.HandleFunc("POST /songs/{id}/comments", middleware.AuthMiddleware(handlers.Keys, handlers.CreateComment))
+	mux.HandleFunc("DELETE /comments/{id}", middleware.AuthMiddleware(handlers.Keys, handlers.DeleteComment))
+	mux.HandleFunc("POST /comments/{id}/report", middleware.AuthMiddleware(handlers.Keys, handlers.ReportComment))
 	// Chain routes
 	mux.HandleFunc("GET /chains", handlers.ListChains)
-	mux.HandleFunc("POST /chains", middleware.AuthMiddleware(handlers.JwtSecret, handlers.CreateChain))
+	mux.HandleFunc("POST /chains", middleware.AuthMiddleware(handlers.Keys, handlers.CreateChain))
+	mux.HandleFunc("POST /chains/{id}/fork", middleware.AuthMiddleware(handlers.Keys, handlers.ForkChain))
+	mux.HandleFunc("GET /chains/{id}", handlers.GetChain)
 	mux.HandleFunc("GET /chains/{id}/songs", handlers.GetChainSongs)
-	mux.HandleFunc("POST /chains/{id}/songs", middleware.AuthMiddleware(handlers.JwtSecret, handlers.AddSongToChain))
-	mux.HandleFunc("DELETE /chains/{id}/songs/{songId}", middleware.AuthMiddleware(handlers.JwtSecret, handlers.RemoveSongFromChain))
-	// Last.fm OAuth routes
-	mux.HandleFunc("GET /auth/lastfm", handlers.LastfmStart)
-	mux.HandleFunc("GET /auth/lastfm/callback", handlers.LastfmCallback)
-	// Discord OAuth routes
-	mux.HandleFunc("GET /auth/discord", handlers.DiscordStart)
-	mux.HandleFunc("GET /auth/discord/callback", handlers.DiscordCallback)
+	mux.HandleFunc("POST /chains/{id}/songs", middleware.AuthMiddleware(handlers.Keys, handlers.AddSongToChain))
+	mux.HandleFunc("DELETE /chains/{id}/songs/{songId}", middleware.AuthMiddleware(handlers.Keys, handlers.RemoveSongFromChain))
+	mux.HandleFunc("POST /chains/{id}/follow", middleware.AuthMiddleware(handlers.Keys, handlers.FollowChain))
+	mux.HandleFunc("DELETE /chains/{id}/follow", middleware.AuthMiddleware(handlers.Keys, handlers.UnfollowChain))
+	mux.HandleFunc("GET /me/follows", middleware.AuthMiddleware(handlers.Keys, handlers.ListFollows))
+	mux.HandleFunc("GET /me/feed", middleware.AuthMiddleware(handlers.Keys, handlers.Feed))
+	mux.HandleFunc("GET /search", middleware.OptionalAuth(handlers.Keys, handlers.Search))
+	// Direct swaps
+	mux.HandleFunc("POST /swaps", middleware.AuthMiddleware(handlers.Keys, handlers.CreateSwap))
+	mux.HandleFunc("POST /swaps/{id}/reply", middleware.AuthMiddleware(handlers.Keys, handlers.ReplySwap))
+	mux.HandleFunc("GET /me/inbox", middleware.AuthMiddleware(handlers.Keys, handlers.Inbox))
+	mux.HandleFunc("GET /me/swaps", middleware.AuthMiddleware(handlers.Keys, handlers.SentSwaps))
+	// Notifications about your submitted songs
+	mux.HandleFunc("GET /me/notifications", middleware.AuthMiddleware(handlers.Keys, handlers.ListNotifications))
+	mux.HandleFunc("POST /me/notifications/read", middleware.AuthMiddleware(handlers.Keys, handlers.MarkNotificationsRead))
+	// Live swaps: the stream is Server-Sent Events
+	mux.HandleFunc("GET /live", middleware.AuthMiddleware(handlers.Keys, handlers.LiveStream))
+	mux.HandleFunc("POST /live/matches/{id}/song", middleware.AuthMiddleware(handlers.Keys, handlers.SubmitLiveSong))
+	// Activity stream; ?me=true needs a token, chains and the pool don't
+	mux.HandleFunc("GET /events", middleware.OptionalAuth(handlers.Keys, handlers.Events))
+	// Listening parties; the socket authenticates with a ticket from POST /rooms/{id}/ticket
+	mux.HandleFunc("POST /chains/{id}/rooms", middleware.AuthMiddleware(handlers.Keys, handlers.CreateRoom))
+	mux.HandleFunc("GET /chains/{id}/rooms", handlers.ListRooms)
+	mux.HandleFunc("GET /rooms/{id}", handlers.GetRoom)
+	mux.HandleFunc("POST /rooms/{id}/ticket", middleware.AuthMiddleware(handlers.Keys, handlers.RoomTicket))
+	mux.HandleFunc("GET /rooms/{id}/ws", handlers.RoomSocket)
+	// Public profiles
+	mux.HandleFunc("GET /users/{username}", handlers.GetProfile)
+	mux.HandleFunc("GET /users/{username}/songs", handlers.GetProfileSongs)
+	mux.HandleFunc("GET /users/{username}/likes", handlers.GetProfileLikes)
+	// OAuth routes (Discord, Last.fm, GitHub, Google, Spotify and any configured OIDC provider)
+	mux.HandleFunc("GET /auth/providers", handlers.ListProviders)
+	mux.HandleFunc("GET /auth/{provider}", handlers.OAuthStart)
+	mux.HandleFunc("GET /auth/{provider}/callback", handlers.OAuthCallback)
+	// Account linking routes
+	mux.HandleFunc("GET /me/links", middleware.AuthMiddleware(handlers.Keys, handlers.ListLinks))
+	mux.HandleFunc("POST /me/links/{provider}/start", middleware.AuthMiddleware(handlers.Keys, handlers.StartLink))
+	mux.HandleFunc("DELETE /me/links/{provider}", middleware.AuthMiddleware(handlers.Keys, handlers.Unlink))
 
 	handler := middleware.CORS(apiLimiter.Limit(mux))
 
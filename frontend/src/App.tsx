@@ -1,24 +1,20 @@
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import Auth from "./Auth";
 import Discover from "./Discover";
 import History from "./History";
 import "./App.css";
 import Chains from "./Chains";
-import type { Chain } from "./api";
+import { exchangeCode, logout, type Chain } from "./api";
 
-function getAuthFromHash() {
-  const hash = window.location.hash;
-  if (hash.includes("token=")) {
-    const params = new URLSearchParams(hash.substring(1));
-    const t = params.get("token");
-    const u = params.get("username");
-    if (t && u) {
-      localStorage.setItem("token", t);
-      localStorage.setItem("username", u);
-      window.history.replaceState(null, "", window.location.pathname);
-      return { token: t, username: u };
-    }
-  }
+// OAuth logins come back with a one-time #code=..., never the tokens themselves
+function takeCodeFromHash() {
+  const params = new URLSearchParams(window.location.hash.substring(1));
+  const code = params.get("code");
+  if (code) window.history.replaceState(null, "", window.location.pathname);
+  return code;
+}
+
+function getStoredAuth() {
   return {
     token: localStorage.getItem("token"),
     username: localStorage.getItem("username"),
@@ -26,23 +22,38 @@ function getAuthFromHash() {
 }
 
 function App() {
-  const [auth] = useState(getAuthFromHash);
+  const [auth] = useState(getStoredAuth);
   const [token, setToken] = useState<string | null>(auth.token);
   const [username, setUsername] = useState<string | null>(auth.username);
   const [page, setPage] = useState<"discover" | "history" | "chains">(
     "discover",
   );
   const [activeChain, setActiveChain] = useState<Chain | null>(null);
+  const [mfaToken, setMfaToken] = useState<string | null>(null);
 
-  function handleLogin(token: string, username: string) {
+  function handleLogin(token: string, refreshToken: string, username: string) {
     localStorage.setItem("token", token);
+    localStorage.setItem("refresh_token", refreshToken);
     localStorage.setItem("username", username);
     setToken(token);
     setUsername(username);
   }
 
+  useEffect(() => {
+    const code = takeCodeFromHash();
+    if (!code) return;
+    exchangeCode(code)
+      .then((data) => {
+        if (data.mfa_required) setMfaToken(data.mfa_token);
+        else handleLogin(data.token, data.refresh_token, data.user.username);
+      })
+      .catch(() => {});
+  }, []);
+
   function handleLogout() {
+    logout();
     localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
     localStorage.removeItem("username");
     setToken(null);
     setUsername(null);
@@ -58,7 +69,7 @@ function App() {
   }
 
   if (!token) {
-    return <Auth onLogin={handleLogin} />;
+    return <Auth key={mfaToken} onLogin={handleLogin} mfaToken={mfaToken} />;
   }
 
   return (
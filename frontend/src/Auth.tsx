@@ -1,16 +1,22 @@
 import { useState } from "react";
-import { login, register } from "./api";
+import { login, register, verifyMFA } from "./api";
 import "./Auth.css";
 
 interface AuthProps {
-  onLogin: (token: string, username: string) => void;
+  onLogin: (token: string, refreshToken: string, username: string) => void;
+  // Set when an OAuth login still needs a second factor
+  mfaToken?: string | null;
 }
 
-export default function Auth({ onLogin }: AuthProps) {
+export default function Auth({ onLogin, mfaToken: initialMfaToken }: AuthProps) {
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
   const [isRegister, setIsRegister] = useState(false);
   const [error, setError] = useState("");
+  const [mfaToken, setMfaToken] = useState<string | null>(
+    initialMfaToken ?? null,
+  );
+  const [code, setCode] = useState("");
 
   async function handleSubmit(e: React.FormEvent) {
     e.preventDefault();
@@ -19,12 +25,56 @@ export default function Auth({ onLogin }: AuthProps) {
     try {
       const fn = isRegister ? register : login;
       const data = await fn(username, password);
-      onLogin(data.token, data.user.username);
+      if (data.mfa_required) {
+        setMfaToken(data.mfa_token);
+        return;
+      }
+      onLogin(data.token, data.refresh_token, data.user.username);
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Something went wrong");
+    }
+  }
+
+  async function handleMFASubmit(e: React.FormEvent) {
+    e.preventDefault();
+    if (!mfaToken) return;
+    setError("");
+
+    try {
+      const data = await verifyMFA(mfaToken, code);
+      onLogin(data.token, data.refresh_token, data.user.username);
     } catch (err) {
       setError(err instanceof Error ? err.message : "Something went wrong");
     }
   }
 
+  if (mfaToken) {
+    return (
+      <div className="auth-container">
+        <h1 className="auth-logo">
+          song<span>swap</span>
+        </h1>
+        <p className="auth-tagline">enter the code from your authenticator</p>
+
+        <form onSubmit={handleMFASubmit} className="auth-form">
+          <input
+            type="text"
+            inputMode="numeric"
+            autoComplete="one-time-code"
+            placeholder="code or recovery code"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            className="auth-input"
+          />
+          {error && <p className="auth-error">{error}</p>}
+          <button type="submit" className="auth-button">
+            verify
+          </button>
+        </form>
+      </div>
+    );
+  }
+
   return (
     <div className="auth-container">
       <h1 className="auth-logo">
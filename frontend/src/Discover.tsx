@@ -10,6 +10,7 @@ interface Song {
   platform: string;
   context_crumb: string | null;
   created_at: string;
+  reactions?: Record<string, number>;
 }
 
 interface DiscoverProps {
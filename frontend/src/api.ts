@@ -20,6 +20,17 @@ export async function login(username: string, password: string) {
   return res.json();
 }
 
+// Trades the one-time code from an OAuth redirect for a session
+export async function exchangeCode(code: string) {
+  const res = await fetch(`${API_URL}/auth/exchange`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ code }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
 export async function submitSong(
   token: string,
   url: string,
@@ -70,16 +81,60 @@ export async function getHistory(token: string) {
   return res.json();
 }
 
+// Only one refresh may be in flight: refresh tokens are single-use, and
+// presenting the same one twice revokes the whole session.
+let refreshing: Promise<string | null> | null = null;
+
+async function refreshAccessToken(): Promise<string | null> {
+  const refreshToken = localStorage.getItem("refresh_token");
+  if (!refreshToken) return null;
+  const res = await fetch(`${API_URL}/auth/refresh`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refresh_token: refreshToken }),
+  });
+  if (!res.ok) return null;
+  const data = await res.json();
+  localStorage.setItem("token", data.token);
+  localStorage.setItem("refresh_token", data.refresh_token);
+  return data.token;
+}
+
 async function authFetch(url: string, options: RequestInit = {}) {
-  const res = await fetch(url, options);
+  let res = await fetch(url, withToken(options, localStorage.getItem("token")));
+  if (res.status === 401) {
+    refreshing ??= refreshAccessToken().finally(() => (refreshing = null));
+    const token = await refreshing;
+    if (token) {
+      res = await fetch(url, withToken(options, token));
+    }
+  }
   if (res.status === 401) {
     localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
     localStorage.removeItem("username");
     window.location.reload();
   }
   return res;
 }
 
+function withToken(options: RequestInit, token: string | null): RequestInit {
+  if (!token) return options;
+  const headers = new Headers(options.headers);
+  headers.set("Authorization", `Bearer ${token}`);
+  return { ...options, headers };
+}
+
+export async function logout() {
+  const refreshToken = localStorage.getItem("refresh_token");
+  if (!refreshToken) return;
+  await fetch(`${API_URL}/auth/logout`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ refresh_token: refreshToken }),
+  }).catch(() => {});
+}
+
 // Chain types and API functions
 
 export interface Chain {
@@ -89,11 +144,30 @@ export interface Chain {
   created_by: number;
   creator_name?: string;
   song_count: number;
+  forked_from?: number;
+  forked_from_name?: string;
+  fork_count: number;
+  like_count: number;
+  trending_score: number;
   created_at: string;
 }
 
-export async function getChains(): Promise<Chain[]> {
-  const res = await fetch(`${API_URL}/chains`);
+export type ChainSort = "new" | "trending" | "most_songs" | "most_liked";
+
+export async function getChains(sort: ChainSort = "new"): Promise<Chain[]> {
+  const res = await fetch(`${API_URL}/chains?sort=${sort}`);
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export interface ChainDetail extends Chain {
+  contributors: { user_id: number; username: string; song_count: number }[];
+  top_songs: { song: { id: number; url: string; platform: string; context_crumb?: string }; likes: number }[];
+  last_activity_at?: string;
+}
+
+export async function getChain(chainId: number): Promise<ChainDetail> {
+  const res = await fetch(`${API_URL}/chains/${chainId}`);
   if (!res.ok) throw new Error(await res.text());
   return res.json();
 }
@@ -150,3 +224,856 @@ export async function removeSongFromChain(
   if (!res.ok) throw new Error(await res.text());
   return res.json();
 }
+
+// Linked accounts
+
+export interface LinkedAccount {
+  provider: "discord" | "lastfm";
+  provider_username: string;
+  linked_at: string;
+}
+
+export async function getLinks(token: string): Promise<LinkedAccount[]> {
+  const res = await authFetch(`${API_URL}/me/links`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Starts the provider flow; the callback sends the browser back with #linked=<provider>
+export async function startLink(token: string, provider: LinkedAccount["provider"]) {
+  const res = await authFetch(`${API_URL}/me/links/${provider}/start`, {
+    method: "POST",
+    headers: { Authorization: `Bearer ${token}` },
+    credentials: "include",
+  });
+  if (!res.ok) throw new Error(await res.text());
+  const data = await res.json();
+  window.location.href = data.url;
+}
+
+export async function unlink(token: string, provider: LinkedAccount["provider"]) {
+  const res = await authFetch(`${API_URL}/me/links/${provider}`, {
+    method: "DELETE",
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// New OAuth users get user.needs_username from exchangeCode and may replace their generated name once
+export async function chooseUsername(token: string, username: string) {
+  const res = await authFetch(`${API_URL}/me/username`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ username }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Passwords and email
+
+export async function changePassword(
+  token: string,
+  currentPassword: string,
+  newPassword: string,
+) {
+  const res = await authFetch(`${API_URL}/me/password`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({
+      current_password: currentPassword,
+      new_password: newPassword,
+    }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export interface EmailStatus {
+  email: string | null;
+  verified: boolean;
+}
+
+export async function getEmail(token: string): Promise<EmailStatus> {
+  const res = await authFetch(`${API_URL}/me/email`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function setEmail(
+  token: string,
+  email: string,
+): Promise<EmailStatus> {
+  const res = await authFetch(`${API_URL}/me/email`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ email }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Verification links arrive as #verify_email=..., reset links as #reset_token=...
+export async function verifyEmail(token: string) {
+  const res = await fetch(`${API_URL}/auth/verify-email`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function forgotPassword(email: string) {
+  const res = await fetch(`${API_URL}/auth/forgot`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function resetPassword(token: string, newPassword: string) {
+  const res = await fetch(`${API_URL}/auth/reset`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token, new_password: newPassword }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Two-factor authentication
+
+// Login and exchangeCode return this instead of tokens when the account has 2FA
+export interface MFAChallenge {
+  mfa_required: true;
+  mfa_token: string;
+}
+
+export async function verifyMFA(mfaToken: string, code: string) {
+  const res = await fetch(`${API_URL}/auth/2fa`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ mfa_token: mfaToken, code }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export interface TwoFactorStatus {
+  enabled: boolean;
+  recovery_codes_remaining: number;
+}
+
+export async function getTwoFactorStatus(
+  token: string,
+): Promise<TwoFactorStatus> {
+  const res = await authFetch(`${API_URL}/me/2fa`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Returns the secret and an otpauth:// URI to show as a QR code
+export async function setupTOTP(
+  token: string,
+): Promise<{ secret: string; uri: string }> {
+  const res = await authFetch(`${API_URL}/me/2fa/setup`, {
+    method: "POST",
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+async function postTOTPCode(token: string, path: string, code: string) {
+  const res = await authFetch(`${API_URL}${path}`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ code }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function enableTOTP(
+  token: string,
+  code: string,
+): Promise<{ recovery_codes: string[] }> {
+  return postTOTPCode(token, "/me/2fa/enable", code);
+}
+
+export async function disableTOTP(token: string, code: string) {
+  return postTOTPCode(token, "/me/2fa/disable", code);
+}
+
+export async function regenerateRecoveryCodes(
+  token: string,
+  code: string,
+): Promise<{ recovery_codes: string[] }> {
+  return postTOTPCode(token, "/me/2fa/recovery-codes", code);
+}
+
+// Personal access tokens
+
+export type TokenScope =
+  | "songs:read"
+  | "songs:write"
+  | "chains:read"
+  | "chains:write"
+  | "read";
+
+export interface APIToken {
+  id: number;
+  name: string;
+  scopes: TokenScope[];
+  created_at: string;
+  last_used_at: string | null;
+  expires_at: string | null;
+}
+
+export async function getAPITokens(token: string): Promise<APIToken[]> {
+  const res = await authFetch(`${API_URL}/me/tokens`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// The returned token is only ever shown once
+export async function createAPIToken(
+  token: string,
+  name: string,
+  scopes: TokenScope[],
+  expiresInDays = 0,
+): Promise<APIToken & { token: string }> {
+  const res = await authFetch(`${API_URL}/me/tokens`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ name, scopes, expires_in_days: expiresInDays }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function revokeAPIToken(token: string, id: number) {
+  const res = await authFetch(`${API_URL}/me/tokens/${id}`, {
+    method: "DELETE",
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Account export and deletion
+
+// Downloads everything we store about the user as JSON, or a ZIP of JSON files
+export async function exportAccount(
+  token: string,
+  format: "json" | "zip" = "json",
+): Promise<Blob> {
+  const res = await authFetch(`${API_URL}/me/export?format=${format}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.blob();
+}
+
+export interface DeleteAccountOptions {
+  password?: string;
+  // Accounts without a password confirm with their username instead
+  confirm?: string;
+  code?: string;
+  chains?: "transfer" | "delete";
+}
+
+export async function deleteAccount(
+  token: string,
+  options: DeleteAccountOptions,
+) {
+  const res = await authFetch(`${API_URL}/me`, {
+    method: "DELETE",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify(options),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Profiles
+
+export interface Profile {
+  username: string;
+  display_name: string | null;
+  bio: string | null;
+  avatar_url: string | null;
+  joined_at: string;
+  submission_count: number;
+  chain_count: number;
+  likes_received: number;
+  show_submissions: boolean;
+  show_likes: boolean;
+}
+
+export async function getProfile(username: string): Promise<Profile> {
+  const res = await fetch(
+    `${API_URL}/users/${encodeURIComponent(username)}`,
+  );
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Only available when the user opted in with show_submissions
+export async function getProfileSongs(username: string, offset = 0) {
+  const res = await fetch(
+    `${API_URL}/users/${encodeURIComponent(username)}/songs?offset=${offset}`,
+  );
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Only available when the user opted in with show_likes
+export async function getProfileLikes(username: string, offset = 0) {
+  const res = await fetch(
+    `${API_URL}/users/${encodeURIComponent(username)}/likes?offset=${offset}`,
+  );
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Omitted fields are left alone; an empty string clears a text field
+export interface ProfileUpdate {
+  display_name?: string;
+  bio?: string;
+  avatar_url?: string;
+  show_submissions?: boolean;
+  show_likes?: boolean;
+}
+
+export async function updateProfile(
+  token: string,
+  update: ProfileUpdate,
+): Promise<Profile> {
+  const res = await authFetch(`${API_URL}/me`, {
+    method: "PATCH",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify(update),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export type SwapStatus = "pending" | "completed" | "expired";
+
+export interface SwapSong {
+  id: number;
+  url: string;
+  platform: string;
+  context_crumb?: string;
+  created_at: string;
+}
+
+// song is left out of inbox entries until the swap is completed
+export interface Swap {
+  id: number;
+  sender: string;
+  recipient: string;
+  note?: string;
+  song?: SwapSong;
+  reply_song?: SwapSong;
+  reply_note?: string;
+  status: SwapStatus;
+  created_at: string;
+  expires_at: string;
+  completed_at?: string;
+}
+
+// Either the song_id of a song you submitted or discovered, or a new url
+export interface SwapSongInput {
+  song_id?: number;
+  url?: string;
+  context_crumb?: string;
+  note?: string;
+}
+
+export async function createSwap(
+  token: string,
+  recipient: string,
+  song: SwapSongInput,
+): Promise<Swap> {
+  const res = await authFetch(`${API_URL}/swaps`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ recipient, ...song }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function replySwap(
+  token: string,
+  swapId: number,
+  song: SwapSongInput,
+): Promise<Swap> {
+  const res = await authFetch(`${API_URL}/swaps/${swapId}/reply`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify(song),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function getInbox(token: string, offset = 0): Promise<Swap[]> {
+  const res = await authFetch(`${API_URL}/me/inbox?offset=${offset}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function getSentSwaps(token: string, offset = 0): Promise<Swap[]> {
+  const res = await authFetch(`${API_URL}/me/swaps?offset=${offset}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export interface ServerEvent {
+  event: string;
+  id?: string;
+  data: unknown;
+}
+
+// Reads a Server-Sent Events stream through authFetch, so the access token
+// goes in a header rather than the URL. Resolves when the stream ends.
+export async function streamEvents(
+  url: string,
+  onEvent: (ev: ServerEvent) => void,
+  signal?: AbortSignal,
+  lastEventId?: string,
+) {
+  const headers: Record<string, string> = { Accept: "text/event-stream" };
+  if (lastEventId) headers["Last-Event-ID"] = lastEventId;
+  const res = await authFetch(url, { headers, signal });
+  if (!res.ok || !res.body) throw new Error(await res.text());
+
+  const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) return;
+    buffer += value;
+
+    let end;
+    while ((end = buffer.indexOf("\n\n")) !== -1) {
+      const block = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
+
+      const ev: ServerEvent = { event: "message", data: null };
+      let data = "";
+      for (const line of block.split("\n")) {
+        // Lines starting with ":" are heartbeats
+        if (line.startsWith("event: ")) ev.event = line.slice(7);
+        else if (line.startsWith("id: ")) ev.id = line.slice(4);
+        else if (line.startsWith("data: ")) data += line.slice(6);
+      }
+      if (data) {
+        ev.data = JSON.parse(data);
+        onEvent(ev);
+      }
+    }
+  }
+}
+
+export type LiveEventType =
+  | "queued"
+  | "matched"
+  | "reveal"
+  | "timeout"
+  | "partner_left";
+
+export interface LiveEvent {
+  type: LiveEventType;
+  match_id?: string;
+  deadline?: string;
+  song?: SwapSong;
+}
+
+// Queues for an anonymous live swap. The stream ends after a reveal, or a
+// timeout without a song; call again to play another round.
+export function joinLiveSwap(
+  onEvent: (ev: LiveEvent) => void,
+  signal?: AbortSignal,
+) {
+  return streamEvents(
+    `${API_URL}/live`,
+    (ev) => onEvent({ type: ev.event as LiveEventType, ...(ev.data as object) }),
+    signal,
+  );
+}
+
+export async function submitLiveSong(
+  token: string,
+  matchId: string,
+  song: Omit<SwapSongInput, "note">,
+) {
+  const res = await authFetch(`${API_URL}/live/matches/${matchId}/song`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify(song),
+  });
+  if (!res.ok) throw new Error(await res.text());
+}
+
+export type ActivityEventType =
+  | "song-added"
+  | "song-removed"
+  | "chain-updated"
+  | "swap-received"
+  | "swap-completed"
+  | "notification"
+  | "resync";
+
+export interface ActivityEvent {
+  type: ActivityEventType;
+  topic: string;
+  // song-added: a song on a chain, only { id, platform, created_at } on the pool; song-removed: { song_id }; chain-updated: { chain_id, change }; swaps: a Swap;
+  // notification: a Notification
+  data: unknown;
+}
+
+export interface ActivitySubscription {
+  chains?: number[];
+  pool?: boolean;
+  // Your own swap activity; needs to be signed in
+  me?: boolean;
+}
+
+// Follows /events until aborted, reconnecting after drops and resuming from
+// the last event seen. A "resync" event means events were missed and
+// whatever is on screen should be refetched.
+export async function subscribeActivity(
+  sub: ActivitySubscription,
+  onEvent: (ev: ActivityEvent) => void,
+  signal: AbortSignal,
+) {
+  const params = new URLSearchParams();
+  for (const id of sub.chains ?? []) params.append("chain", String(id));
+  if (sub.pool) params.set("pool", "true");
+  if (sub.me) params.set("me", "true");
+
+  let lastEventId: string | undefined;
+  while (!signal.aborted) {
+    try {
+      await streamEvents(
+        `${API_URL}/events?${params}`,
+        (ev) => {
+          if (ev.id) lastEventId = ev.id;
+          const body = ev.data as { topic?: string; data?: unknown };
+          onEvent({
+            type: ev.event as ActivityEventType,
+            topic: body.topic ?? "",
+            data: body.data,
+          });
+        },
+        signal,
+        lastEventId,
+      );
+    } catch (err) {
+      if (signal.aborted) return;
+      console.warn("Activity stream dropped:", err);
+    }
+    await new Promise((resolve) => setTimeout(resolve, 3000));
+  }
+}
+
+export interface Room {
+  id: string;
+  chain_id: number;
+  host: string;
+  members: number;
+  now_playing?: SwapSong;
+  created_at: string;
+}
+
+// position_ms is where playback was at server_time (Unix ms, server clock)
+export interface PlaybackState {
+  song: SwapSong | null;
+  playing: boolean;
+  position_ms: number;
+  server_time: number;
+}
+
+export type RoomCommand =
+  | { type: "play"; song_id?: number }
+  | { type: "pause" }
+  | { type: "seek"; position_ms: number }
+  | { type: "next"; song_id?: number }
+  | { type: "end" }
+  | { type: "react"; emoji: string }
+  | { type: "suggest"; song_id: number }
+  | { type: "ping"; client_time: number };
+
+export interface RoomMessage {
+  type:
+    | "welcome"
+    | "state"
+    | "queue"
+    | "members"
+    | "reaction"
+    | "pong"
+    | "error"
+    | "closed";
+  data?: unknown;
+  server_time: number;
+}
+
+export const REACTIONS = ["🔥", "❤️", "😂", "😮", "😢", "👏", "🎶"];
+
+export async function createRoom(token: string, chainId: number): Promise<Room> {
+  const res = await authFetch(`${API_URL}/chains/${chainId}/rooms`, {
+    method: "POST",
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function getChainRooms(chainId: number): Promise<Room[]> {
+  const res = await fetch(`${API_URL}/chains/${chainId}/rooms`);
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Opens a room's WebSocket. Browsers can't send an Authorization header on a
+// WebSocket, so this first trades the token for a single-use ticket.
+export async function joinRoom(
+  token: string,
+  roomId: string,
+  onMessage: (msg: RoomMessage) => void,
+): Promise<{ send: (cmd: RoomCommand) => void; close: () => void }> {
+  const res = await authFetch(`${API_URL}/rooms/${roomId}/ticket`, {
+    method: "POST",
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  const { ticket } = await res.json();
+
+  const wsURL = API_URL.replace(/^http/, "ws");
+  const socket = new WebSocket(
+    `${wsURL}/rooms/${roomId}/ws?ticket=${encodeURIComponent(ticket)}`,
+  );
+  socket.onmessage = (ev) => onMessage(JSON.parse(ev.data));
+  await new Promise<void>((resolve, reject) => {
+    socket.onopen = () => resolve();
+    socket.onerror = () => reject(new Error("Failed to join room"));
+  });
+
+  return {
+    send: (cmd) => socket.send(JSON.stringify(cmd)),
+    close: () => socket.close(),
+  };
+}
+
+// Estimates serverClock - localClock from a pong, assuming the trip was symmetric
+export function clockOffset(pong: RoomMessage, receivedAt = Date.now()) {
+  const sentAt = (pong.data as { client_time: number }).client_time;
+  return pong.server_time - (sentAt + receivedAt) / 2;
+}
+
+// Where playback should be right now, for correcting a drifting player
+export function currentPosition(state: PlaybackState, offsetMs = 0) {
+  if (!state.playing) return state.position_ms;
+  return state.position_ms + (Date.now() + offsetMs - state.server_time);
+}
+
+export interface ReactionSummary {
+  song_id: number;
+  counts: Record<string, number>;
+  mine: string[];
+}
+
+export async function getReactions(token: string, songId: number): Promise<ReactionSummary> {
+  const res = await authFetch(`${API_URL}/songs/${songId}/reactions`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function addReaction(
+  token: string,
+  songId: number,
+  emoji: string,
+): Promise<ReactionSummary> {
+  const res = await authFetch(`${API_URL}/songs/${songId}/reactions`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ emoji }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function removeReaction(
+  token: string,
+  songId: number,
+  emoji: string,
+): Promise<ReactionSummary> {
+  const res = await authFetch(
+    `${API_URL}/songs/${songId}/reactions/${encodeURIComponent(emoji)}`,
+    {
+      method: "DELETE",
+      headers: { Authorization: `Bearer ${token}` },
+    },
+  );
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// status is only set on your own comments that others can't see yet
+export interface SongComment {
+  id: number;
+  song_id: number;
+  username: string;
+  body: string;
+  status?: "held" | "hidden";
+  mine: boolean;
+  created_at: string;
+}
+
+export async function getComments(
+  token: string,
+  songId: number,
+  offset = 0,
+): Promise<SongComment[]> {
+  const res = await authFetch(`${API_URL}/songs/${songId}/comments?offset=${offset}`, {
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function postComment(
+  token: string,
+  songId: number,
+  body: string,
+): Promise<SongComment> {
+  const res = await authFetch(`${API_URL}/songs/${songId}/comments`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ body }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function deleteComment(token: string, commentId: number) {
+  const res = await authFetch(`${API_URL}/comments/${commentId}`, {
+    method: "DELETE",
+    headers: { Authorization: `Bearer ${token}` },
+  });
+  if (!res.ok) throw new Error(await res.text());
+}
+
+export async function reportComment(token: string, commentId: number, reason?: string) {
+  const res = await authFetch(`${API_URL}/comments/${commentId}/report`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ reason }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+}
+
+export type NotificationKind = "discovered" | "liked" | "added_to_chain";
+
+// count is how many times it happened; unread notifications keep counting up
+export interface Notification {
+  id: number;
+  kind: NotificationKind;
+  song: SwapSong;
+  chain_id?: number;
+  chain_name?: string;
+  count: number;
+  read: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+export async function getNotifications(
+  token: string,
+  { unreadOnly = false, offset = 0 } = {},
+): Promise<{ unread: number; notifications: Notification[] }> {
+  const res = await authFetch(
+    `${API_URL}/me/notifications?unread=${unreadOnly}&offset=${offset}`,
+    { headers: { Authorization: `Bearer ${token}` } },
+  );
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+// Marks the given notifications read, or all of them without ids
+export async function markNotificationsRead(
+  token: string,
+  ids?: number[],
+): Promise<{ unread: number }> {
+  const res = await authFetch(`${API_URL}/me/notifications/read`, {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+      Authorization: `Bearer ${token}`,
+    },
+    body: JSON.stringify({ ids }),
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
@@ -2,10 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var DB *sql.DB
@@ -27,4 +28,10 @@ func Connect() error {
 
 	DB = db
 	return nil
-}
\ No newline at end of file
+}
+
+// IsUniqueViolation reports whether err is a Postgres unique_violation (23505)
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
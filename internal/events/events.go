@@ -0,0 +1,197 @@
+// Package events is an in-process publish/subscribe bus for activity that
+// clients follow live, such as songs being added to a chain. Recent events
+// are kept in a bounded buffer so a reconnecting client can catch up.
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReplaySize is how many recent events are kept for clients that reconnect
+const DefaultReplaySize = 1000
+
+// subscriberBuffer is how many events a subscriber can fall behind before it's dropped
+const subscriberBuffer = 64
+
+// Event types
+const (
+	SongAdded     = "song-added"
+	SongRemoved   = "song-removed"
+	ChainUpdated  = "chain-updated"
+	SwapReceived  = "swap-received"
+	SwapCompleted = "swap-completed"
+	Notification  = "notification"
+	// Resync tells a client that events it asked to resume from are gone, so
+	// it should refetch whatever it's showing
+	Resync = "resync"
+)
+
+// PoolTopic announces songs submitted to the global pool, without their URLs
+const PoolTopic = "pool"
+
+// ChainTopic carries changes to one chain
+func ChainTopic(chainID int64) string {
+	return "chain:" + strconv.FormatInt(chainID, 10)
+}
+
+// UserTopic carries events meant only for one user
+func UserTopic(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10)
+}
+
+// Event is one published change. ID is unique to this process, so a client
+// resuming with an ID from before a restart is told to resync.
+type Event struct {
+	ID    string    `json:"-"`
+	Type  string    `json:"-"`
+	Topic string    `json:"topic"`
+	Data  any       `json:"data"`
+	Time  time.Time `json:"time"`
+
+	seq uint64
+}
+
+// Subscription receives events for a fixed set of topics. Events is closed if
+// the subscriber falls too far behind; it should reconnect and resume.
+type Subscription struct {
+	topics map[string]bool
+	events chan Event
+	bus    *Bus
+}
+
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
+
+type Bus struct {
+	mu         sync.Mutex
+	boot       string
+	seq        uint64
+	replaySize int
+	// history holds the most recent events, oldest first
+	history []Event
+	subs    map[*Subscription]bool
+}
+
+func NewBus(replaySize int) *Bus {
+	return &Bus{
+		boot:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		replaySize: replaySize,
+		subs:       make(map[*Subscription]bool),
+	}
+}
+
+// Publish sends an event to every subscriber of topic and keeps it for replay.
+// It never blocks on a slow subscriber.
+func (b *Bus) Publish(topic, eventType string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	ev := Event{
+		ID:    fmt.Sprintf("%s-%d", b.boot, b.seq),
+		Type:  eventType,
+		Topic: topic,
+		Data:  data,
+		Time:  time.Now(),
+		seq:   b.seq,
+	}
+
+	if len(b.history) == b.replaySize && b.replaySize > 0 {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	if b.replaySize > 0 {
+		b.history = append(b.history, ev)
+	}
+
+	for sub := range b.subs {
+		if !sub.topics[topic] {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			b.drop(sub)
+		}
+	}
+	return ev
+}
+
+// Subscribe starts delivering events for topics. With a lastEventID, the
+// events published since then are returned for replay; complete is false if
+// some of them are no longer buffered.
+func (b *Bus) Subscribe(topics []string, lastEventID string) (sub *Subscription, replay []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{
+		topics: make(map[string]bool, len(topics)),
+		events: make(chan Event, subscriberBuffer),
+		bus:    b,
+	}
+	for _, t := range topics {
+		sub.topics[t] = true
+	}
+	b.subs[sub] = true
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+
+	since, ok := b.parseID(lastEventID)
+	if !ok {
+		return sub, nil, false
+	}
+
+	// The next event the client needs has to still be buffered
+	complete = since == b.seq || (len(b.history) > 0 && b.history[0].seq <= since+1)
+	for _, ev := range b.history {
+		if ev.seq > since && sub.topics[ev.Topic] {
+			replay = append(replay, ev)
+		}
+	}
+	return sub, replay, complete
+}
+
+// parseID reads the sequence number from an ID published by this bus
+func (b *Bus) parseID(id string) (uint64, bool) {
+	boot, seq, found := strings.Cut(id, "-")
+	if !found || boot != b.boot {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil || n > b.seq {
+		return 0, false
+	}
+	return n, true
+}
+
+// drop removes a subscriber and closes its channel; the caller holds b.mu
+func (b *Bus) drop(sub *Subscription) {
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
+
+// LastID is the ID of the most recent event, or "" if nothing was published yet
+func (b *Bus) LastID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.seq == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", b.boot, b.seq)
+}
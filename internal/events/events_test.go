@@ -0,0 +1,91 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestPublishFiltersTopics(t *testing.T) {
+	b := NewBus(10)
+	sub, _, _ := b.Subscribe([]string{ChainTopic(1)}, "")
+	defer sub.Close()
+
+	b.Publish(ChainTopic(2), SongAdded, 1)
+	b.Publish(ChainTopic(1), SongAdded, 2)
+
+	ev := <-sub.Events()
+	if ev.Topic != "chain:1" || ev.Data != 2 || ev.Type != SongAdded {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	select {
+	case ev := <-sub.Events():
+		t.Errorf("unexpected extra event %+v", ev)
+	default:
+	}
+}
+
+func TestResume(t *testing.T) {
+	b := NewBus(10)
+	first := b.Publish(PoolTopic, SongAdded, 1)
+	b.Publish(ChainTopic(1), SongAdded, 2)
+	b.Publish(PoolTopic, SongAdded, 3)
+
+	sub, replay, complete := b.Subscribe([]string{PoolTopic}, first.ID)
+	defer sub.Close()
+
+	if !complete {
+		t.Error("expected a complete replay")
+	}
+	if len(replay) != 1 || replay[0].Data != 3 {
+		t.Errorf("expected only the later pool event, got %+v", replay)
+	}
+
+	// Resuming from the latest event replays nothing and misses nothing
+	last := b.Publish(PoolTopic, SongAdded, 4)
+	_, replay, complete = b.Subscribe([]string{PoolTopic}, last.ID)
+	if !complete || len(replay) != 0 {
+		t.Errorf("expected an empty complete replay, got %d events, complete=%v", len(replay), complete)
+	}
+}
+
+func TestResumeTooOld(t *testing.T) {
+	b := NewBus(2)
+	first := b.Publish(PoolTopic, SongAdded, 1)
+	b.Publish(PoolTopic, SongAdded, 2)
+	b.Publish(PoolTopic, SongAdded, 3)
+	b.Publish(PoolTopic, SongAdded, 4)
+
+	_, replay, complete := b.Subscribe([]string{PoolTopic}, first.ID)
+	if complete {
+		t.Error("expected event 2 to be reported missing")
+	}
+	if len(replay) != 2 {
+		t.Errorf("expected the 2 buffered events, got %d", len(replay))
+	}
+
+	// IDs from another process, or made up, can't be resumed from
+	for _, id := range []string{"other-1", "garbage", first.ID + "0"} {
+		if _, _, complete := b.Subscribe([]string{PoolTopic}, id); complete {
+			t.Errorf("expected %q to need a resync", id)
+		}
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	b := NewBus(0)
+	sub, _, _ := b.Subscribe([]string{PoolTopic}, "")
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(PoolTopic, SongAdded, i)
+	}
+
+	n := 0
+	for range sub.Events() {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("expected %d buffered events before the channel closed, got %d", subscriberBuffer, n)
+	}
+
+	// Closing a dropped subscription is harmless
+	sub.Close()
+}
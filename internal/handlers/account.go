@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/events"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Chain policies for account deletion
+const (
+	// chainsTransfer hands each owned chain to its most active other
+	// contributor; chains nobody else added to are deleted
+	chainsTransfer = "transfer"
+	chainsDelete   = "delete"
+)
+
+// ExportAccount returns everything we store about the user, as one JSON
+// document or, with ?format=zip, a ZIP with one JSON file per section
+func ExportAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		http.Error(w, "Format must be json or zip", http.StatusBadRequest)
+		return
+	}
+
+	// One snapshot, so the sections agree with each other
+	tx, err := database.DB.BeginTx(r.Context(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		http.Error(w, "Failed to export account", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	export, err := buildExport(tx, userID)
+	if err != nil {
+		log.Println("ExportAccount DB error:", err)
+		http.Error(w, "Failed to export account", http.StatusInternalServerError)
+		return
+	}
+
+	// Older usernames predate validation, so keep the header safe
+	filename := fmt.Sprintf("songswap-export-%s-%s",
+		sanitizeUsername(export.Profile.Username, "user"), export.ExportedAt.Format("2006-01-02"))
+
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		if err := writeExportZip(w, export); err != nil {
+			log.Println("ExportAccount zip error:", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(export)
+}
+
+func buildExport(tx *sql.Tx, userID int64) (*models.Export, error) {
+	export := &models.Export{
+		ExportedAt:     time.Now().UTC(),
+		Submissions:    []models.Song{},
+		Discoveries:    []models.ExportDiscovery{},
+		Chains:         []models.Chain{},
+		ChainAdditions: []models.ExportChainAddition{},
+		Follows:        []models.ExportFollow{},
+		LinkedAccounts: []models.LinkedAccount{},
+		Swaps:          []models.ExportSwap{},
+		Reactions:      []models.ExportReaction{},
+		Comments:       []models.ExportComment{},
+		Notifications:  []models.ExportNotification{},
+	}
+
+	p := &export.Profile
+	err := tx.QueryRow(`
+		SELECT id, username, display_name, bio, avatar_url, show_submissions, show_likes,
+			email, email_verified_at, totp_enabled_at IS NOT NULL, created_at
+		FROM users WHERE id = $1
+	`, userID).Scan(&p.ID, &p.Username, &p.DisplayName, &p.Bio, &p.AvatarURL, &p.ShowSubmissions, &p.ShowLikes,
+		&p.Email, &p.EmailVerifiedAt, &p.TwoFactorEnabled, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, url, platform, context_crumb, submitted_by, created_at
+		FROM songs WHERE submitted_by = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s models.Song
+		if err := rows.Scan(&s.ID, &s.URL, &s.Platform, &s.ContextCrumb, &s.SubmittedBy, &s.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Submissions = append(export.Submissions, s)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at, d.liked, d.liked_at, d.discovered_at
+		FROM discoveries d
+		JOIN songs s ON d.song_id = s.id
+		WHERE d.user_id = $1
+		ORDER BY d.discovered_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d models.ExportDiscovery
+		if err := rows.Scan(&d.Song.ID, &d.Song.URL, &d.Song.Platform, &d.Song.ContextCrumb, &d.Song.CreatedAt,
+			&d.Liked, &d.LikedAt, &d.DiscoveredAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Discoveries = append(export.Discoveries, d)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT c.id, c.name, c.description, c.created_by, c.forked_from, c.created_at,
+			(SELECT COUNT(*) FROM chain_songs WHERE chain_id = c.id)
+		FROM chains c
+		WHERE c.created_by = $1
+		ORDER BY c.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c models.Chain
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.CreatedBy, &c.ForkedFrom, &c.CreatedAt, &c.SongCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Chains = append(export.Chains, c)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT c.id, c.name, s.id, s.url, cs.added_at
+		FROM chain_songs cs
+		JOIN chains c ON cs.chain_id = c.id
+		JOIN songs s ON cs.song_id = s.id
+		WHERE cs.added_by = $1
+		ORDER BY cs.added_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a models.ExportChainAddition
+		if err := rows.Scan(&a.ChainID, &a.ChainName, &a.SongID, &a.SongURL, &a.AddedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.ChainAdditions = append(export.ChainAdditions, a)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT c.id, c.name, f.followed_at
+		FROM chain_follows f
+		JOIN chains c ON f.chain_id = c.id
+		WHERE f.user_id = $1
+		ORDER BY f.followed_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var f models.ExportFollow
+		if err := rows.Scan(&f.ChainID, &f.ChainName, &f.FollowedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Follows = append(export.Follows, f)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT provider, provider_username, linked_at
+		FROM linked_accounts WHERE user_id = $1
+		ORDER BY linked_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var l models.LinkedAccount
+		if err := rows.Scan(&l.Provider, &l.ProviderUsername, &l.LinkedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.LinkedAccounts = append(export.LinkedAccounts, l)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT sw.id,
+			CASE WHEN sw.sender_id = $1 THEN 'sent' ELSE 'received' END,
+			CASE WHEN sw.sender_id = $1 THEN ru.username ELSE su.username END,
+			CASE WHEN sw.sender_id = $1 OR sw.status = 'completed' THEN sw.song_id END,
+			sw.note, sw.reply_song_id, sw.reply_note, sw.status, sw.created_at, sw.completed_at
+		FROM swaps sw
+		JOIN users su ON sw.sender_id = su.id
+		JOIN users ru ON sw.recipient_id = ru.id
+		WHERE sw.sender_id = $1 OR sw.recipient_id = $1
+		ORDER BY sw.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s models.ExportSwap
+		if err := rows.Scan(&s.ID, &s.Direction, &s.With, &s.SongID, &s.Note, &s.ReplySongID, &s.ReplyNote,
+			&s.Status, &s.CreatedAt, &s.CompletedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Swaps = append(export.Swaps, s)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT song_id, emoji, created_at
+		FROM song_reactions WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var re models.ExportReaction
+		if err := rows.Scan(&re.SongID, &re.Emoji, &re.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Reactions = append(export.Reactions, re)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT id, song_id, body, status, created_at
+		FROM song_comments WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c models.ExportComment
+		if err := rows.Scan(&c.ID, &c.SongID, &c.Body, &c.Status, &c.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Comments = append(export.Comments, c)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT id, kind, song_id, chain_id, count, created_at, updated_at, read_at
+		FROM notifications WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var n models.ExportNotification
+		if err := rows.Scan(&n.ID, &n.Kind, &n.SongID, &n.ChainID, &n.Count, &n.CreatedAt, &n.UpdatedAt, &n.ReadAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		export.Notifications = append(export.Notifications, n)
+	}
+	rows.Close()
+
+	return export, rows.Err()
+}
+
+// writeExportZip writes each section of an export as its own JSON file
+func writeExportZip(w http.ResponseWriter, export *models.Export) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", export.Profile},
+		{"submissions.json", export.Submissions},
+		{"discoveries.json", export.Discoveries},
+		{"chains.json", export.Chains},
+		{"chain_additions.json", export.ChainAdditions},
+		{"follows.json", export.Follows},
+		{"linked_accounts.json", export.LinkedAccounts},
+		{"swaps.json", export.Swaps},
+		{"reactions.json", export.Reactions},
+		{"comments.json", export.Comments},
+		{"notifications.json", export.Notifications},
+	}
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// queryIDs runs a statement that returns one ID per row
+func queryIDs(tx *sql.Tx, query string, args ...any) ([]int64, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteAccount permanently deletes the user. Submitted songs stay in the pool
+// without an owner, discoveries and linked accounts are removed, and owned
+// chains are transferred or deleted. It takes the password (or, without one,
+// the username) and a 2FA code if enabled.
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Chains == "" {
+		req.Chains = chainsTransfer
+	}
+	if req.Chains != chainsTransfer && req.Chains != chainsDelete {
+		http.Error(w, "Chains must be transfer or delete", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var username string
+	var passwordHash, totpSecret sql.NullString
+	var totpLastStep int64
+	var totpEnabled bool
+	err = tx.QueryRow(`
+		SELECT username, password_hash, totp_secret, totp_last_step, totp_enabled_at IS NOT NULL
+		FROM users WHERE id = $1
+		FOR UPDATE
+	`, userID).Scan(&username, &passwordHash, &totpSecret, &totpLastStep, &totpEnabled)
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	if passwordHash.Valid {
+		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(req.Password)); err != nil {
+			http.Error(w, "Password is incorrect", http.StatusForbidden)
+			return
+		}
+	} else if req.Confirm != username {
+		http.Error(w, "Type your username to confirm", http.StatusBadRequest)
+		return
+	}
+
+	if totpEnabled {
+		valid, err := checkSecondFactor(tx, userID, totpSecret.String, totpLastStep, req.Code)
+		if err != nil {
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			http.Error(w, "Invalid code", http.StatusForbidden)
+			return
+		}
+	}
+
+	var resp models.DeleteAccountResponse
+	var transferred, deleted []int64
+	if req.Chains == chainsTransfer {
+		// The contributor with the most songs in the chain takes it over, earliest first on a tie
+		transferred, err = queryIDs(tx, `
+			UPDATE chains c SET created_by = t.user_id
+			FROM (
+				SELECT DISTINCT ON (cs.chain_id) cs.chain_id, cs.added_by AS user_id
+				FROM chain_songs cs
+				JOIN chains ch ON ch.id = cs.chain_id
+				WHERE ch.created_by = $1 AND cs.added_by IS NOT NULL AND cs.added_by <> $1
+				GROUP BY cs.chain_id, cs.added_by
+				ORDER BY cs.chain_id, COUNT(*) DESC, MIN(cs.added_at)
+			) t
+			WHERE c.id = t.chain_id
+			RETURNING c.id
+		`, userID)
+		if err != nil {
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+		resp.ChainsTransferred = len(transferred)
+	}
+
+	deleted, err = queryIDs(tx, `DELETE FROM chains WHERE created_by = $1 RETURNING id`, userID)
+	if err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	resp.ChainsDeleted = len(deleted)
+
+	for _, stmt := range []string{
+		// Keep the pool: songs and chain entries survive without an owner
+		`UPDATE songs SET submitted_by = NULL WHERE submitted_by = $1`,
+		`UPDATE chain_songs SET added_by = NULL WHERE added_by = $1`,
+		`DELETE FROM discoveries WHERE user_id = $1`,
+		`DELETE FROM linked_accounts WHERE user_id = $1`,
+		// Sessions, tokens, follows and the rest cascade
+		`DELETE FROM users WHERE id = $1`,
+	} {
+		if _, err := tx.Exec(stmt, userID); err != nil {
+			log.Println("DeleteAccount DB error:", err)
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	for _, id := range transferred {
+		eventBus.Publish(events.ChainTopic(id), events.ChainUpdated,
+			models.ChainUpdatedEvent{ChainID: id, Change: models.ChainTransferred})
+	}
+	for _, id := range deleted {
+		eventBus.Publish(events.ChainTopic(id), events.ChainUpdated,
+			models.ChainUpdatedEvent{ChainID: id, Change: models.ChainDeleted})
+	}
+
+	clearAuthCookies(w, r)
+
+	resp.Deleted = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
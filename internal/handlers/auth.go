@@ -1,20 +1,24 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/keyring"
+	"github.com/halva/songswap/internal/middleware"
 	"github.com/halva/songswap/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var JwtSecret []byte
+var Keys *keyring.Keyring
 
-func SetJwtSecret(secret []byte) {
-	JwtSecret = secret
+func SetKeyring(keys *keyring.Keyring) {
+	Keys = keys
 }
 
 func Register(w http.ResponseWriter, r *http.Request) {
@@ -35,13 +39,8 @@ func Register(w http.ResponseWriter, r *http.Request) {
     	return
 	}
 
-	if len(req.Password) < 8 {
-    	http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
-    	return
-	}
-
-	if len(req.Password) > 72 {
-    	http.Error(w, "Password must be under 72 characters", http.StatusBadRequest)
+	if msg := validatePassword(req.Password); msg != "" {
+    	http.Error(w, msg, http.StatusBadRequest)
     	return
 	}
 
@@ -60,13 +59,16 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		RETURNING id, username, created_at
 	`, req.Username, string(hash)).Scan(&user.ID, &user.Username, &user.CreatedAt)
 
-	if err != nil {
+	if database.IsUniqueViolation(err) {
 		http.Error(w, "Username already taken", http.StatusConflict)
 		return
+	} else if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
 	}
 
-	// Create token
-	token, err := createToken(user.ID)
+	// Start a session
+	tokens, err := startSession(r, user.ID, loginPassword)
 	if err != nil {
 		http.Error(w, "Failed to create token", http.StatusInternalServerError)
 		return
@@ -74,7 +76,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(models.AuthResponse{Token: token, User: user})
+	json.NewEncoder(w).Encode(authResponse(tokens, user))
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
@@ -85,42 +87,89 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Refuse before comparing anything while the username or IP is locked out
+	ip := middleware.RealIP(r)
+	wait, err := loginLockedFor(req.Username, ip)
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeLockedOut(w, wait)
+		return
+	}
+
 	// Find user
 	var user models.User
-	var passwordHash string
-	err := database.DB.QueryRow(`
+	var passwordHash sql.NullString
+	err = database.DB.QueryRow(`
 		SELECT id, username, password_hash, created_at
 		FROM users
 		WHERE username = $1
 	`, req.Username).Scan(&user.ID, &user.Username, &passwordHash, &user.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	// Check password. Unknown and OAuth-only accounts still pay for a bcrypt
+	// comparison, so timing doesn't reveal which usernames exist.
+	if err == nil && passwordHash.Valid {
+		err = bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(req.Password))
+	} else {
+		burnPasswordCheck(req.Password)
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+	}
 
 	if err != nil {
+		if err := recordLoginFailure(req.Username, ip); err != nil {
+			log.Println("Login failure tracking error:", err)
+		}
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Check password
-	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	// Accounts with 2FA get a challenge to complete at /auth/2fa instead of
+	// tokens. Their failures are only cleared once that succeeds.
+	mfaToken, required, err := startMFAChallenge(user.ID, loginPassword)
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		writeMFAChallenge(w, mfaToken)
 		return
 	}
 
-	// Create token
-	token, err := createToken(user.ID)
+	// Start a session
+	tokens, err := startSession(r, user.ID, loginPassword)
 	if err != nil {
 		http.Error(w, "Failed to create token", http.StatusInternalServerError)
 		return
 	}
 
+	if err := clearLoginFailures(req.Username); err != nil {
+		log.Println("Login failure tracking error:", err)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.AuthResponse{Token: token, User: user})
+	json.NewEncoder(w).Encode(authResponse(tokens, user))
 }
 
-func createToken(userID int64) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// createToken signs a short-lived access token bound to a session
+func createToken(userID, sessionID int64) (string, error) {
+	return Keys.Sign(jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
+		"sid":     sessionID,
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
 	})
+}
 
-	return token.SignedString(JwtSecret)
+// JWKS publishes the public keys that verify our tokens
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(Keys.JWKS())
 }
\ No newline at end of file
@@ -1,25 +1,40 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/events"
 	"github.com/halva/songswap/internal/middleware"
 	"github.com/halva/songswap/internal/models"
 )
 
-// ListChains returns all chains with song counts
+// ListChains returns all chains with song counts and fork lineage.
+// ?sort= is one of new (default), trending, most_songs or most_liked.
 func ListChains(w http.ResponseWriter, r *http.Request) {
+	orderBy, ok := chainOrderBy(r.URL.Query().Get("sort"))
+	if !ok {
+		http.Error(w, "Sort must be trending, new, most_songs or most_liked", http.StatusBadRequest)
+		return
+	}
+
+	// orderBy comes from a fixed whitelist, never from user input
 	rows, err := database.DB.Query(`
 		SELECT c.id, c.name, c.description, c.created_by, u.username, c.created_at,
-			COUNT(cs.song_id) AS song_count
+			(SELECT COUNT(*) FROM chain_songs cs WHERE cs.chain_id = c.id) AS song_count,
+			c.forked_from, p.name,
+			(SELECT COUNT(*) FROM chains f WHERE f.forked_from = c.id) AS fork_count,
+			COALESCE(sc.like_count, 0), COALESCE(sc.trending_score, 0)
 		FROM chains c
 		JOIN users u ON c.created_by = u.id
-		LEFT JOIN chain_songs cs ON c.id = cs.chain_id
-		GROUP BY c.id, u.username
-		ORDER BY c.created_at DESC
-	`)
+		LEFT JOIN chains p ON c.forked_from = p.id
+		LEFT JOIN chain_scores sc ON sc.chain_id = c.id
+		ORDER BY ` + orderBy)
 	if err != nil {
 		http.Error(w, "Failed to fetch chains", http.StatusInternalServerError)
 		return
@@ -29,7 +44,8 @@ func ListChains(w http.ResponseWriter, r *http.Request) {
 	chains := []models.Chain{}
 	for rows.Next() {
 		var c models.Chain
-		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.CreatedBy, &c.CreatorName, &c.CreatedAt, &c.SongCount)
+		err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.CreatedBy, &c.CreatorName, &c.CreatedAt, &c.SongCount,
+			&c.ForkedFrom, &c.ForkedFromName, &c.ForkCount, &c.LikeCount, &c.TrendingScore)
 		if err != nil {
 			continue
 		}
@@ -40,6 +56,110 @@ func ListChains(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(chains)
 }
 
+// GetChain returns a chain's metadata together with contributor and like stats
+func GetChain(w http.ResponseWriter, r *http.Request) {
+	chainID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || chainID < 1 {
+		http.Error(w, "Invalid chain ID", http.StatusBadRequest)
+		return
+	}
+
+	var detail models.ChainDetail
+	c := &detail.Chain
+	err = database.DB.QueryRow(`
+		SELECT c.id, c.name, c.description, c.created_by, u.username, c.created_at,
+			(SELECT COUNT(*) FROM chain_songs cs WHERE cs.chain_id = c.id) AS song_count,
+			c.forked_from, p.name,
+			(SELECT COUNT(*) FROM chains f WHERE f.forked_from = c.id) AS fork_count,
+			(SELECT MAX(cs.added_at) FROM chain_songs cs WHERE cs.chain_id = c.id) AS last_activity_at,
+			COALESCE(sc.trending_score, 0)
+		FROM chains c
+		JOIN users u ON c.created_by = u.id
+		LEFT JOIN chains p ON c.forked_from = p.id
+		LEFT JOIN chain_scores sc ON sc.chain_id = c.id
+		WHERE c.id = $1
+	`, chainID).Scan(&c.ID, &c.Name, &c.Description, &c.CreatedBy, &c.CreatorName, &c.CreatedAt, &c.SongCount,
+		&c.ForkedFrom, &c.ForkedFromName, &c.ForkCount, &detail.LastActivityAt, &c.TrendingScore)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Chain not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch chain", http.StatusInternalServerError)
+		return
+	}
+
+	if detail.LastActivityAt == nil {
+		detail.LastActivityAt = &c.CreatedAt
+	}
+
+	// Contributors, most active first
+	rows, err := database.DB.Query(`
+		SELECT u.id, u.username, COUNT(*) AS song_count
+		FROM chain_songs cs
+		JOIN users u ON cs.added_by = u.id
+		WHERE cs.chain_id = $1
+		GROUP BY u.id, u.username
+		ORDER BY song_count DESC, u.username
+	`, chainID)
+	if err != nil {
+		http.Error(w, "Failed to fetch contributors", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	detail.Contributors = []models.ChainContributor{}
+	for rows.Next() {
+		var contributor models.ChainContributor
+		if err := rows.Scan(&contributor.UserID, &contributor.Username, &contributor.SongCount); err != nil {
+			continue
+		}
+		detail.Contributors = append(detail.Contributors, contributor)
+	}
+
+	// Likes come from discoveries of the chain's songs, by anyone. Counted live
+	// rather than taken from chain_scores, which is only refreshed periodically.
+	err = database.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM chain_songs cs
+		JOIN discoveries d ON d.song_id = cs.song_id
+		WHERE cs.chain_id = $1 AND d.liked = true
+	`, chainID).Scan(&c.LikeCount)
+	if err != nil {
+		http.Error(w, "Failed to fetch likes", http.StatusInternalServerError)
+		return
+	}
+
+	topRows, err := database.DB.Query(`
+		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at, COUNT(d.id) AS likes
+		FROM chain_songs cs
+		JOIN songs s ON cs.song_id = s.id
+		JOIN discoveries d ON d.song_id = s.id AND d.liked = true
+		WHERE cs.chain_id = $1
+		GROUP BY s.id
+		ORDER BY likes DESC, s.created_at DESC
+		LIMIT 5
+	`, chainID)
+	if err != nil {
+		http.Error(w, "Failed to fetch top songs", http.StatusInternalServerError)
+		return
+	}
+	defer topRows.Close()
+
+	detail.TopSongs = []models.ChainTopSong{}
+	for topRows.Next() {
+		var top models.ChainTopSong
+		err := topRows.Scan(&top.Song.ID, &top.Song.URL, &top.Song.Platform, &top.Song.ContextCrumb,
+			&top.Song.CreatedAt, &top.Likes)
+		if err != nil {
+			continue
+		}
+		detail.TopSongs = append(detail.TopSongs, top)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
 // CreateChain creates a new chain
 func CreateChain(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
@@ -48,6 +168,10 @@ func CreateChain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireScope(w, r, middleware.ScopeChainsWrite) {
+		return
+	}
+
 	var req models.CreateChainRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -90,6 +214,116 @@ func CreateChain(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(chain)
 }
 
+// ForkChain creates a new chain owned by the caller with a copy of another chain's songs
+func ForkChain(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeChainsWrite) {
+		return
+	}
+
+	chainID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || chainID < 1 {
+		http.Error(w, "Invalid chain ID", http.StatusBadRequest)
+		return
+	}
+
+	// Body is optional; name and description default to the source chain's
+	var req models.ForkChainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil && *req.Name == "" {
+		http.Error(w, "Chain name is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != nil && len(*req.Name) > 50 {
+		http.Error(w, "Chain name must be under 50 characters", http.StatusBadRequest)
+		return
+	}
+
+	if req.Description != nil && len(*req.Description) > 200 {
+		http.Error(w, "Description must be under 200 characters", http.StatusBadRequest)
+		return
+	}
+
+	var source models.Chain
+	err = database.DB.QueryRow(
+		"SELECT id, name, description FROM chains WHERE id = $1", chainID,
+	).Scan(&source.ID, &source.Name, &source.Description)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Chain not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fork chain", http.StatusInternalServerError)
+		return
+	}
+
+	name := source.Name
+	if req.Name != nil {
+		name = *req.Name
+	}
+	description := source.Description
+	if req.Description != nil {
+		description = req.Description
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to fork chain", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var chain models.Chain
+	err = tx.QueryRow(`
+		INSERT INTO chains (name, description, created_by, forked_from)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, name, description, created_by, forked_from, created_at
+	`, name, description, userID, source.ID).Scan(
+		&chain.ID, &chain.Name, &chain.Description, &chain.CreatedBy, &chain.ForkedFrom, &chain.CreatedAt,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fork chain", http.StatusInternalServerError)
+		return
+	}
+
+	// Copy membership, keeping the original contributors and timestamps
+	result, err := tx.Exec(`
+		INSERT INTO chain_songs (chain_id, song_id, added_by, added_at)
+		SELECT $1, song_id, added_by, added_at
+		FROM chain_songs
+		WHERE chain_id = $2
+	`, chain.ID, source.ID)
+	if err != nil {
+		http.Error(w, "Failed to copy chain songs", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to fork chain", http.StatusInternalServerError)
+		return
+	}
+
+	copied, _ := result.RowsAffected()
+	chain.SongCount = int(copied)
+	chain.ForkedFromName = &source.Name
+
+	eventBus.Publish(events.ChainTopic(source.ID), events.ChainUpdated,
+		models.ChainUpdatedEvent{ChainID: source.ID, Change: models.ChainForked})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(chain)
+}
+
 // GetChainSongs returns all songs in a chain
 func GetChainSongs(w http.ResponseWriter, r *http.Request) {
 	chainID := r.PathValue("id")
@@ -122,6 +356,8 @@ func GetChainSongs(w http.ResponseWriter, r *http.Request) {
 		songs = append(songs, s)
 	}
 
+	attachReactions(songPointers(songs))
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(songs)
 }
@@ -134,6 +370,10 @@ func AddSongToChain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireScope(w, r, middleware.ScopeChainsWrite) {
+		return
+	}
+
 	chainID := r.PathValue("id")
 	if chainID == "" {
 		http.Error(w, "Chain ID required", http.StatusBadRequest)
@@ -151,15 +391,23 @@ func AddSongToChain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify song exists
-	var exists bool
-	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM songs WHERE id = $1)", req.SongID).Scan(&exists)
-	if err != nil || !exists {
+	// Verify song exists, keeping it for the song-added event. A song sent in a
+	// swap can only be added by someone who already has it.
+	var song models.Song
+	err := database.DB.QueryRow(`
+		SELECT id, url, platform, context_crumb, created_at FROM songs s
+		WHERE id = $1 AND (
+			in_pool
+			OR submitted_by = $2
+			OR EXISTS(SELECT 1 FROM discoveries WHERE song_id = s.id AND user_id = $2)
+		)
+	`, req.SongID, userID).Scan(&song.ID, &song.URL, &song.Platform, &song.ContextCrumb, &song.CreatedAt)
+	if err != nil {
 		http.Error(w, "Song not found", http.StatusNotFound)
 		return
 	}
 
-	_, err = database.DB.Exec(`
+	result, err := database.DB.Exec(`
 		INSERT INTO chain_songs (chain_id, song_id, added_by)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (chain_id, song_id) DO NOTHING
@@ -170,6 +418,13 @@ func AddSongToChain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The insert only succeeds for a numeric chain ID
+	if n, _ := result.RowsAffected(); n > 0 {
+		id, _ := strconv.ParseInt(chainID, 10, 64)
+		eventBus.Publish(events.ChainTopic(id), events.SongAdded, song)
+		notify(models.NotifyAddedToChain, song.ID, &id, userID)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte(`{"added": true}`))
@@ -183,6 +438,10 @@ func RemoveSongFromChain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireScope(w, r, middleware.ScopeChainsWrite) {
+		return
+	}
+
 	chainID := r.PathValue("id")
 	songID := r.PathValue("songId")
 	if chainID == "" || songID == "" {
@@ -218,6 +477,10 @@ func RemoveSongFromChain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	id, _ := strconv.ParseInt(chainID, 10, 64)
+	removed, _ := strconv.ParseInt(songID, 10, 64)
+	eventBus.Publish(events.ChainTopic(id), events.SongRemoved, models.SongRemovedEvent{SongID: removed})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"removed": true}`))
 }
\ No newline at end of file
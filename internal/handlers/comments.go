@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/halva/songswap/internal/moderation"
+)
+
+const (
+	maxCommentLength = 500
+	maxReportReason  = 200
+	// commentReportThreshold is how many people have to report a comment
+	// before it's hidden pending review
+	commentReportThreshold = 3
+)
+
+var Moderator moderation.Moderator = moderation.AllowAll{}
+
+func SetModerator(m moderation.Moderator) {
+	Moderator = m
+}
+
+// validateComment trims the body and returns a user-facing error message, or "" if it's acceptable
+func validateComment(req *models.CreateCommentRequest) string {
+	req.Body = strings.TrimSpace(req.Body)
+	if req.Body == "" {
+		return "Comment can't be empty"
+	}
+	if utf8.RuneCountInString(req.Body) > maxCommentLength {
+		return "Comment must be at most 500 characters"
+	}
+	return ""
+}
+
+// ListComments returns a song's thread, oldest first. Callers also see their
+// own comments that are held or hidden, marked with their status.
+func ListComments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsRead) {
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r, 50)
+	if !ok {
+		return
+	}
+
+	songID, ok := requireSongParticipant(w, userID, r.PathValue("id"), "Failed to fetch comments")
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT c.id, c.song_id, u.username, c.body, c.status, c.user_id = $2, c.created_at
+		FROM song_comments c
+		JOIN users u ON c.user_id = u.id
+		WHERE c.song_id = $1 AND (c.status = 'visible' OR c.user_id = $2)
+		ORDER BY c.created_at, c.id
+		LIMIT $3 OFFSET $4
+	`, songID, userID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	comments := []models.Comment{}
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.SongID, &c.Username, &c.Body, &c.Status, &c.Mine, &c.CreatedAt); err != nil {
+			continue
+		}
+		if c.Status == models.CommentVisible {
+			c.Status = ""
+		}
+		comments = append(comments, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comments)
+}
+
+// CreateComment adds to a song's thread after the moderator has reviewed it
+func CreateComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	var req models.CreateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateComment(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	songID, ok := requireSongParticipant(w, userID, r.PathValue("id"), "Failed to post comment")
+	if !ok {
+		return
+	}
+
+	decision, err := Moderator.Review(r.Context(), moderation.Comment{SongID: songID, UserID: userID, Body: req.Body})
+	if err != nil {
+		// Don't publish what couldn't be checked
+		log.Println("Comment moderation failed:", err)
+		decision = moderation.Hold
+	}
+	if decision == moderation.Reject {
+		http.Error(w, "This comment isn't allowed", http.StatusUnprocessableEntity)
+		return
+	}
+
+	status := models.CommentVisible
+	if decision == moderation.Hold {
+		status = models.CommentHeld
+	}
+
+	c := models.Comment{SongID: songID, Body: req.Body, Mine: true}
+	err = database.DB.QueryRow(`
+		INSERT INTO song_comments (song_id, user_id, body, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, (SELECT username FROM users WHERE id = $2)
+	`, songID, userID, req.Body, status).Scan(&c.ID, &c.CreatedAt, &c.Username)
+	if err != nil {
+		http.Error(w, "Failed to post comment", http.StatusInternalServerError)
+		return
+	}
+	if status != models.CommentVisible {
+		c.Status = status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// DeleteComment removes a comment. Its author can, and so can whoever
+// submitted the song, since it's their song's thread.
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	commentID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	var authorID int64
+	var submitterID *int64
+	err = database.DB.QueryRow(`
+		SELECT c.user_id, s.submitted_by
+		FROM song_comments c
+		JOIN songs s ON c.song_id = s.id
+		WHERE c.id = $1
+	`, commentID).Scan(&authorID, &submitterID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	if authorID != userID && (submitterID == nil || *submitterID != userID) {
+		http.Error(w, "You can't delete this comment", http.StatusForbidden)
+		return
+	}
+
+	if _, err := database.DB.Exec("DELETE FROM song_comments WHERE id = $1", commentID); err != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReportComment flags a comment for review. Once enough different people have
+// reported it, it's hidden from everyone but its author.
+func ReportComment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	commentID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ReportCommentRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Reason != nil && utf8.RuneCountInString(*req.Reason) > maxReportReason {
+		http.Error(w, "Reason must be at most 200 characters", http.StatusBadRequest)
+		return
+	}
+
+	var songID, authorID int64
+	err = database.DB.QueryRow(
+		"SELECT song_id, user_id FROM song_comments WHERE id = $1", commentID,
+	).Scan(&songID, &authorID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to report comment", http.StatusInternalServerError)
+		return
+	}
+
+	if authorID == userID {
+		http.Error(w, "You can't report your own comment", http.StatusBadRequest)
+		return
+	}
+
+	// Only people who can see the thread can report in it
+	_, allowed, err := songParticipant(userID, songID)
+	if err != nil {
+		http.Error(w, "Failed to report comment", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO comment_reports (comment_id, user_id, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, commentID, userID, req.Reason)
+	if err != nil {
+		http.Error(w, "Failed to report comment", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE song_comments SET status = 'hidden'
+		WHERE id = $1 AND status = 'visible'
+		AND (SELECT COUNT(*) FROM comment_reports WHERE comment_id = $1) >= $2
+	`, commentID, commentReportThreshold)
+	if err != nil {
+		http.Error(w, "Failed to report comment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"reported": true}`))
+}
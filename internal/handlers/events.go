@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/halva/songswap/internal/events"
+	"github.com/halva/songswap/internal/middleware"
+)
+
+// eventBus carries activity to /events subscribers. It is in-process, so
+// subscribers only see changes made through the same API instance.
+var eventBus = events.NewBus(events.DefaultReplaySize)
+
+const maxEventChains = 20
+
+// parseEventTopics reads ?chain= (repeatable), ?pool=true and ?me=true.
+// The user's own topic is added by the caller once they're authenticated.
+func parseEventTopics(r *http.Request) (topics []string, wantsUser bool, msg string) {
+	query := r.URL.Query()
+
+	chains := query["chain"]
+	if len(chains) > maxEventChains {
+		return nil, false, "At most 20 chains can be followed at once"
+	}
+	for _, c := range chains {
+		id, err := strconv.ParseInt(c, 10, 64)
+		if err != nil || id < 1 {
+			return nil, false, "Invalid chain ID"
+		}
+		topics = append(topics, events.ChainTopic(id))
+	}
+
+	if query.Get("pool") == "true" {
+		topics = append(topics, events.PoolTopic)
+	}
+
+	wantsUser = query.Get("me") == "true"
+	if len(topics) == 0 && !wantsUser {
+		return nil, false, "Subscribe to at least one of chain, pool or me"
+	}
+	return topics, wantsUser, ""
+}
+
+// Events streams chain, pool and personal activity as Server-Sent Events.
+// Chains and the pool are public; ?me=true needs a signed-in user.
+func Events(w http.ResponseWriter, r *http.Request) {
+	topics, wantsUser, msg := parseEventTopics(r)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if wantsUser {
+		userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !requireScope(w, r, middleware.ScopeSongsRead) {
+			return
+		}
+
+		topics = append(topics, events.UserTopic(userID))
+	}
+
+	// EventSource resends the last ID it saw in this header when it reconnects
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	sub, replay, complete := eventBus.Subscribe(topics, lastEventID)
+	defer sub.Close()
+
+	flusher, ok := startSSE(w)
+	if !ok {
+		return
+	}
+
+	if complete {
+		for _, ev := range replay {
+			if writeSSE(w, ev.Type, ev.ID, ev) != nil {
+				return
+			}
+		}
+	} else {
+		// Too much was missed to replay; the ID moves the client past it
+		if writeSSE(w, events.Resync, eventBus.LastID(), struct{}{}) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if writeSSEHeartbeat(w) != nil {
+				return
+			}
+		case ev, ok := <-sub.Events():
+			// Closed when this client fell behind; it reconnects and resumes
+			if !ok {
+				return
+			}
+			if writeSSE(w, ev.Type, ev.ID, ev) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
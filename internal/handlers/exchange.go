@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+const (
+	loginCodeTTL = time.Minute
+	// refreshTokenCookie is only sent to /auth, where Refresh and Logout live
+	refreshTokenCookie = "songswap_refresh"
+)
+
+// createLoginCode stores a single-use code that Exchange will trade for a session
+func createLoginCode(userID int64, method string) (string, error) {
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO login_codes (code_hash, user_id, login_method, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, hashToken(code), userID, method, time.Now().Add(loginCodeTTL))
+	if err != nil {
+		return "", err
+	}
+
+	// Opportunistically clear out codes nobody exchanged
+	database.DB.Exec(`DELETE FROM login_codes WHERE expires_at < NOW()`)
+	return code, nil
+}
+
+// Exchange trades a one-time login code from an OAuth callback for a session
+func Exchange(w http.ResponseWriter, r *http.Request) {
+	var req models.ExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	var userID int64
+	var method string
+	err := database.DB.QueryRow(`
+		DELETE FROM login_codes
+		WHERE code_hash = $1 AND expires_at > NOW()
+		RETURNING user_id, login_method
+	`, hashToken(req.Code)).Scan(&userID, &method)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to exchange code", http.StatusInternalServerError)
+		return
+	}
+
+	var user models.User
+	err = database.DB.QueryRow(`
+		SELECT id, username, needs_username, created_at FROM users WHERE id = $1
+	`, userID).Scan(&user.ID, &user.Username, &user.NeedsUsername, &user.CreatedAt)
+	if err != nil {
+		http.Error(w, "Failed to exchange code", http.StatusInternalServerError)
+		return
+	}
+
+	// An OAuth login is only the first factor when 2FA is on
+	mfaToken, required, err := startMFAChallenge(userID, method)
+	if err != nil {
+		http.Error(w, "Failed to exchange code", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		writeMFAChallenge(w, mfaToken)
+		return
+	}
+
+	tokens, err := startSession(r, userID, method)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if req.UseCookie {
+		setAuthCookies(w, r, tokens)
+		json.NewEncoder(w).Encode(models.AuthResponse{ExpiresIn: int(accessTokenTTL.Seconds()), User: user})
+		return
+	}
+	json.NewEncoder(w).Encode(authResponse(tokens, user))
+}
+
+// setAuthCookies stores a token pair in HttpOnly cookies. SameSite=Strict keeps
+// other sites from riding on them.
+func setAuthCookies(w http.ResponseWriter, r *http.Request, tokens tokenPair) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.AccessTokenCookie,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		MaxAge:   int(accessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    tokens.RefreshToken,
+		Path:     "/auth",
+		MaxAge:   int(refreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearAuthCookies removes the cookies set by setAuthCookies
+func clearAuthCookies(w http.ResponseWriter, r *http.Request) {
+	for name, path := range map[string]string{middleware.AccessTokenCookie: "/", refreshTokenCookie: "/auth"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     path,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+// FollowChain subscribes the user to new songs in a chain
+func FollowChain(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeChainsWrite) {
+		return
+	}
+
+	chainID := r.PathValue("id")
+	if chainID == "" {
+		http.Error(w, "Chain ID required", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM chains WHERE id = $1)", chainID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Chain not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO chain_follows (user_id, chain_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, chain_id) DO NOTHING
+	`, userID, chainID)
+
+	if err != nil {
+		http.Error(w, "Failed to follow chain", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"following": true}`))
+}
+
+// UnfollowChain removes the user's subscription to a chain
+func UnfollowChain(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeChainsWrite) {
+		return
+	}
+
+	chainID := r.PathValue("id")
+	if chainID == "" {
+		http.Error(w, "Chain ID required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		DELETE FROM chain_follows WHERE user_id = $1 AND chain_id = $2
+	`, userID, chainID)
+
+	if err != nil {
+		http.Error(w, "Failed to unfollow chain", http.StatusInternalServerError)
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		http.Error(w, "Not following this chain", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"following": false}`))
+}
+
+// ListFollows returns followed chains with unread counts, without marking anything as seen
+func ListFollows(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeChainsRead) {
+		return
+	}
+
+	follows, err := fetchFollowedChains(database.DB, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch follows", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(follows)
+}
+
+// Feed returns songs added to followed chains since the last check, oldest
+// first, and marks the ones returned as seen, so calling it again gets the
+// next page. ?offset= looks further ahead without marking anything.
+func Feed(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeChainsRead) {
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r, 20)
+	if !ok {
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to fetch feed", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	follows, err := fetchFollowedChains(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch feed", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := tx.Query(`
+		SELECT c.id, c.name, s.id, s.url, s.platform, s.context_crumb, s.created_at, cs.added_at
+		FROM chain_follows f
+		JOIN chains c ON f.chain_id = c.id
+		JOIN chain_songs cs ON cs.chain_id = f.chain_id
+		JOIN songs s ON cs.song_id = s.id
+		WHERE f.user_id = $1
+		AND cs.added_at > f.last_seen_at
+		AND cs.added_by IS DISTINCT FROM $1
+		ORDER BY cs.added_at, cs.id
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch feed", http.StatusInternalServerError)
+		return
+	}
+
+	items := []models.FeedItem{}
+	seen := make(map[int64]time.Time)
+	for rows.Next() {
+		var item models.FeedItem
+		err := rows.Scan(&item.ChainID, &item.ChainName, &item.Song.ID, &item.Song.URL, &item.Song.Platform,
+			&item.Song.ContextCrumb, &item.Song.CreatedAt, &item.AddedAt)
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+		if item.AddedAt.After(seen[item.ChainID]) {
+			seen[item.ChainID] = item.AddedAt
+		}
+	}
+	rows.Close()
+
+	// Only what was returned counts as seen. Marking up to NOW() would skip a
+	// song whose insert began before this read but committed after it, since
+	// its added_at is older than NOW().
+	if offset == 0 {
+		for chainID, addedAt := range seen {
+			_, err = tx.Exec(`
+				UPDATE chain_follows SET last_seen_at = GREATEST(last_seen_at, $3)
+				WHERE user_id = $1 AND chain_id = $2
+			`, userID, chainID, addedAt)
+			if err != nil {
+				http.Error(w, "Failed to update feed", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update feed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.FeedResponse{Chains: follows, Items: items})
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func fetchFollowedChains(q queryer, userID int64) ([]models.FollowedChain, error) {
+	rows, err := q.Query(`
+		SELECT c.id, c.name, f.followed_at, f.last_seen_at,
+			(SELECT COUNT(*) FROM chain_songs cs
+			 WHERE cs.chain_id = f.chain_id
+			 AND cs.added_at > f.last_seen_at
+			 AND cs.added_by IS DISTINCT FROM f.user_id) AS unread
+		FROM chain_follows f
+		JOIN chains c ON f.chain_id = c.id
+		WHERE f.user_id = $1
+		ORDER BY f.followed_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	follows := []models.FollowedChain{}
+	for rows.Next() {
+		var f models.FollowedChain
+		if err := rows.Scan(&f.ChainID, &f.ChainName, &f.FollowedAt, &f.LastSeenAt, &f.Unread); err != nil {
+			continue
+		}
+		follows = append(follows, f)
+	}
+	return follows, rows.Err()
+}
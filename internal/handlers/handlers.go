@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/events"
 	"github.com/halva/songswap/internal/middleware"
 	"github.com/halva/songswap/internal/models"
 )
@@ -25,6 +28,10 @@ func SubmitSong(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
 	
 	var req models.SubmitSongRequest
 
@@ -33,55 +40,37 @@ func SubmitSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.URL == "" {
-		http.Error(w, "URL is required", http.StatusBadRequest)
-		return
-	}
-
-	if len(req.URL) > 2000 {
-		http.Error(w, "URL is too long", http.StatusBadRequest)
-		return
-	}
-
-	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
-		http.Error(w, "URL must start with http:// or https://", http.StatusBadRequest)
+	if msg := validateSongSubmission(req.URL, req.ContextCrumb); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 
-	if !validateURL(req.URL) {
-		http.Error(w, "URL does not exist or is unreachable", http.StatusBadRequest)
-		return
-	}
-
-	if req.ContextCrumb != nil && len(*req.ContextCrumb) > 100 {
-		http.Error(w, "Context crumb must be under 100 characters", http.StatusBadRequest)
-		return
-	}
-
-	platform := detectPlatform(req.URL)
-
-	var song models.Song
-	err := database.DB.QueryRow(`
-		INSERT INTO songs (url, platform, context_crumb, submitted_by)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, url, platform, context_crumb, created_at
-	`, req.URL, platform, req.ContextCrumb, userID).Scan(
-		&song.ID, &song.URL, &song.Platform, &song.ContextCrumb, &song.CreatedAt,
-	)
-
+	song, err := insertSong(database.DB, userID, req.URL, req.ContextCrumb, true)
 	if err != nil {
     log.Println("SubmitSong DB error:", err)
     http.Error(w, "Failed to save song", http.StatusInternalServerError)
     return
 }
 
+	// Only enough to show that something arrived; the song itself is found through Discover
+	eventBus.Publish(events.PoolTopic, events.SongAdded, models.PoolSongEvent{
+		ID:        song.ID,
+		Platform:  song.Platform,
+		CreatedAt: song.CreatedAt,
+	})
+
 	// If a chain_id was provided, add the song to that chain
 	if req.ChainID != nil {
-		database.DB.Exec(`
+		result, err := database.DB.Exec(`
 			INSERT INTO chain_songs (chain_id, song_id, added_by)
 			VALUES ($1, $2, $3)
 			ON CONFLICT (chain_id, song_id) DO NOTHING
 		`, *req.ChainID, song.ID, userID)
+		if err == nil {
+			if n, _ := result.RowsAffected(); n > 0 {
+				eventBus.Publish(events.ChainTopic(*req.ChainID), events.SongAdded, song)
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -89,6 +78,50 @@ func SubmitSong(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(song)
 }
 
+// validateSongSubmission returns a user-facing error message, or "" if the song can be saved
+func validateSongSubmission(url string, contextCrumb *string) string {
+	if url == "" {
+		return "URL is required"
+	}
+
+	if len(url) > 2000 {
+		return "URL is too long"
+	}
+
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "URL must start with http:// or https://"
+	}
+
+	if !validateURL(url) {
+		return "URL does not exist or is unreachable"
+	}
+
+	if contextCrumb != nil && len(*contextCrumb) > 100 {
+		return "Context crumb must be under 100 characters"
+	}
+
+	return ""
+}
+
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// insertSong saves a validated song, through q so callers can make it part of
+// a transaction. Only songs submitted to the pool can be discovered; ones sent
+// straight to someone in a swap are saved with inPool false.
+func insertSong(q rowQueryer, userID int64, url string, contextCrumb *string, inPool bool) (models.Song, error) {
+	var song models.Song
+	err := q.QueryRow(`
+		INSERT INTO songs (url, platform, context_crumb, submitted_by, in_pool)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, url, platform, context_crumb, created_at
+	`, url, detectPlatform(url), contextCrumb, userID, inPool).Scan(
+		&song.ID, &song.URL, &song.Platform, &song.ContextCrumb, &song.CreatedAt,
+	)
+	return song, err
+}
+
 func Discover(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
 	if !ok {
@@ -96,6 +129,10 @@ func Discover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
 	var song models.Song
 	var err error
 
@@ -119,7 +156,7 @@ func Discover(w http.ResponseWriter, r *http.Request) {
 		err = database.DB.QueryRow(`
 			SELECT id, url, platform, context_crumb, created_at
 			FROM songs
-			WHERE id NOT IN (
+			WHERE in_pool AND id NOT IN (
 				SELECT song_id FROM discoveries WHERE user_id = $1
 			)
 			ORDER BY RANDOM()
@@ -143,6 +180,9 @@ func Discover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	notify(models.NotifyDiscovered, song.ID, nil, userID)
+	attachReactions([]*models.Song{&song})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(song)
 }
@@ -204,16 +244,23 @@ func LikeSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	songID := r.PathValue("id")
-	if songID == "" {
-		http.Error(w, "Song ID required", http.StatusBadRequest)
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	songID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid song ID", http.StatusBadRequest)
 		return
 	}
 
-	// Ensure a discovery record exists (for chain likes)
-	_, err := database.DB.Exec(`
+	// Ensure a discovery record exists (for chain likes). Chain songs are public;
+	// any other song has to have been discovered already, since a discovery
+	// also opens the song's thread.
+	_, err = database.DB.Exec(`
 		INSERT INTO discoveries (user_id, song_id)
-		VALUES ($1, $2)
+		SELECT $1::integer, $2::integer
+		WHERE EXISTS(SELECT 1 FROM chain_songs WHERE song_id = $2)
 		ON CONFLICT DO NOTHING
 	`, userID, songID)
 
@@ -222,11 +269,11 @@ func LikeSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Now set liked = true
-	_, err = database.DB.Exec(`
+	// Now set liked = true, unless it already was
+	result, err := database.DB.Exec(`
 		UPDATE discoveries
-		SET liked = true
-		WHERE user_id = $1 AND song_id = $2
+		SET liked = true, liked_at = COALESCE(liked_at, NOW())
+		WHERE user_id = $1 AND song_id = $2 AND liked IS NOT TRUE
 	`, userID, songID)
 
 	if err != nil {
@@ -234,6 +281,24 @@ func LikeSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Liking an already liked song shouldn't notify the submitter again
+	if n, _ := result.RowsAffected(); n > 0 {
+		notify(models.NotifyLiked, songID, nil, userID)
+	} else {
+		var discovered bool
+		err := database.DB.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM discoveries WHERE user_id = $1 AND song_id = $2)", userID, songID,
+		).Scan(&discovered)
+		if err != nil {
+			http.Error(w, "Failed to like song", http.StatusInternalServerError)
+			return
+		}
+		if !discovered {
+			http.Error(w, "Discover this song first", http.StatusForbidden)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"liked": true}`))
 }
@@ -245,8 +310,12 @@ func History(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireScope(w, r, middleware.ScopeSongsRead) {
+		return
+	}
+
 	rows, err := database.DB.Query(`
-		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at, d.liked, d.discovered_at
+		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at, d.liked, d.discovered_at, d.swap_id
 		FROM discoveries d
 		JOIN songs s ON d.song_id = s.id
 		WHERE d.user_id = $1
@@ -260,24 +329,30 @@ func History(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	var discoveries []map[string]interface{}
+	var songs []*models.Song
 
 	for rows.Next() {
 		var song models.Song
 		var liked *bool
 		var discoveredAt time.Time
+		var swapID *int64
 
-		err := rows.Scan(&song.ID, &song.URL, &song.Platform, &song.ContextCrumb, &song.CreatedAt, &liked, &discoveredAt)
+		err := rows.Scan(&song.ID, &song.URL, &song.Platform, &song.ContextCrumb, &song.CreatedAt, &liked, &discoveredAt, &swapID)
 		if err != nil {
 			continue
 		}
 
 		discoveries = append(discoveries, map[string]interface{}{
-			"song":          song,
+			"song":          &song,
 			"liked":         liked,
 			"discovered_at": discoveredAt,
+			"swap_id":       swapID,
 		})
+		songs = append(songs, &song)
 	}
 
+	attachReactions(songs)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(discoveries)
 }
@@ -289,6 +364,10 @@ func UnlikeSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
 	songID := r.PathValue("id")
 	if songID == "" {
 		http.Error(w, "Song ID required", http.StatusBadRequest)
@@ -297,7 +376,7 @@ func UnlikeSong(w http.ResponseWriter, r *http.Request) {
 
 	result, err := database.DB.Exec(`
 		UPDATE discoveries
-		SET liked = NULL
+		SET liked = NULL, liked_at = NULL
 		WHERE user_id = $1 AND song_id = $2
 	`, userID, songID)
 
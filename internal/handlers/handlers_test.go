@@ -1,13 +1,28 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/gorilla/websocket"
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/events"
 	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/halva/songswap/internal/oauth"
+	"github.com/halva/songswap/internal/party"
+	"github.com/halva/songswap/internal/search"
 )
 
 func TestHealth(t *testing.T) {
@@ -138,6 +153,21 @@ func TestRegister_UsernameTooLong(t *testing.T) {
 	}
 }
 
+// Password signups keep their original rules; the character set only applies
+// to usernames picked after an OAuth signup
+func TestRegister_AllowsAnyUsernameCharacters(t *testing.T) {
+	body := strings.NewReader(`{"username":"dj spinz!","password":"short"}`)
+	req := httptest.NewRequest("POST", "/register", body)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	Register(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Password") {
+		t.Errorf("expected only the password to be rejected, got %d %q", w.Code, w.Body.String())
+	}
+}
+
 func TestRegister_PasswordTooShort(t *testing.T) {
 	body := strings.NewReader(`{"username":"validuser","password":"short"}`)
 	req := httptest.NewRequest("POST", "/register", body)
@@ -281,4 +311,1625 @@ func TestDetectPlatform(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestForkChain_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("POST", "/chains/1/fork", nil)
+	req.SetPathValue("id", "1")
+	w := httptest.NewRecorder()
+
+	ForkChain(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestForkChain_NameTooLong(t *testing.T) {
+	longName := strings.Repeat("a", 51)
+	body := strings.NewReader(`{"name":"` + longName + `"}`)
+	req := httptest.NewRequest("POST", "/chains/1/fork", body)
+	req.SetPathValue("id", "1")
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	ForkChain(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestForkChain_InvalidID(t *testing.T) {
+	req := httptest.NewRequest("POST", "/chains/abc/fork", nil)
+	req.SetPathValue("id", "abc")
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	ForkChain(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestFeed_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("GET", "/me/feed", nil)
+	w := httptest.NewRecorder()
+
+	Feed(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestSearch_EmptyQuery(t *testing.T) {
+	req := httptest.NewRequest("GET", "/search?q=", nil)
+	w := httptest.NewRecorder()
+
+	Search(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestSearch_InvalidKind(t *testing.T) {
+	req := httptest.NewRequest("GET", "/search?q=rain&kind=album", nil)
+	w := httptest.NewRecorder()
+
+	Search(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestSearch_GroupsResults(t *testing.T) {
+	store := search.NewMemory()
+	store.AddChain(models.Chain{ID: 7, Name: "rainy day"})
+	SetSearchStore(store)
+	defer SetSearchStore(nil)
+
+	req := httptest.NewRequest("GET", "/search?q=rainy", nil)
+	w := httptest.NewRecorder()
+
+	Search(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var resp models.SearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Chains) != 1 || resp.Chains[0].Kind != models.SearchKindChain {
+		t.Errorf("expected one chain result, got %+v", resp.Chains)
+	}
+}
+
+func TestListChains_InvalidSort(t *testing.T) {
+	req := httptest.NewRequest("GET", "/chains?sort=random", nil)
+	w := httptest.NewRecorder()
+
+	ListChains(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestChainOrderBy(t *testing.T) {
+	for _, sort := range []string{"", "new", "trending", "most_songs", "most_liked"} {
+		if _, ok := chainOrderBy(sort); !ok {
+			t.Errorf("chainOrderBy(%q) should be valid", sort)
+		}
+	}
+	if _, ok := chainOrderBy("created_at; DROP TABLE chains"); ok {
+		t.Error("expected unknown sort to be rejected")
+	}
+}
+
+func TestRefresh_MissingToken(t *testing.T) {
+	body := strings.NewReader(`{}`)
+	req := httptest.NewRequest("POST", "/auth/refresh", body)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	Refresh(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestRefresh_EmptyBody(t *testing.T) {
+	req := httptest.NewRequest("POST", "/auth/refresh", nil)
+	w := httptest.NewRecorder()
+
+	Refresh(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestExchange_MissingCode(t *testing.T) {
+	body := strings.NewReader(`{}`)
+	req := httptest.NewRequest("POST", "/auth/exchange", body)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	Exchange(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestLogoutAll_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("POST", "/auth/logout-all", nil)
+	w := httptest.NewRecorder()
+
+	LogoutAll(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	token, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashToken(token) != hashToken(token) {
+		t.Error("expected hashing to be deterministic")
+	}
+	if len(hashToken(token)) != 64 {
+		t.Errorf("expected 64 hex characters, got %d", len(hashToken(token)))
+	}
+	other, _ := randomToken()
+	if token == other {
+		t.Error("expected random tokens to differ")
+	}
+}
+
+func TestOAuthState_RoundTrip(t *testing.T) {
+	SetOAuthStateSecret([]byte("state-secret"))
+	expires := time.Now().Add(time.Minute)
+	state := signState("discord", "nonce123", expires, "browser-a")
+
+	nonce, err := verifyState(state, "discord", "browser-a", time.Now())
+	if err != nil || nonce != "nonce123" {
+		t.Fatalf("expected nonce123, got %q (%v)", nonce, err)
+	}
+
+	if _, err := verifyState(state, "discord", "browser-b", time.Now()); err == nil {
+		t.Error("expected state from another browser to be rejected")
+	}
+	if _, err := verifyState(state, "lastfm", "browser-a", time.Now()); err == nil {
+		t.Error("expected state for another provider to be rejected")
+	}
+	if _, err := verifyState(state, "discord", "browser-a", expires.Add(time.Second)); err == nil {
+		t.Error("expected expired state to be rejected")
+	}
+	if _, err := verifyState("garbage", "discord", "browser-a", time.Now()); err == nil {
+		t.Error("expected malformed state to be rejected")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Test vector from RFC 7636, Appendix B
+	got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Errorf("pkceChallenge = %q, want %q", got, want)
+	}
+}
+
+func TestOAuthCallback_MissingStateCookie(t *testing.T) {
+	SetProviders(oauth.NewRegistry(oauth.NewLastfm("key", "secret", "http://localhost/auth/lastfm/callback", nil)))
+	defer SetProviders(nil)
+
+	req := httptest.NewRequest("GET", "/auth/lastfm/callback?token=abc&state=x.y.z", nil)
+	req.SetPathValue("provider", "lastfm")
+	w := httptest.NewRecorder()
+
+	OAuthCallback(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestStartLink_UnknownProvider(t *testing.T) {
+	req := httptest.NewRequest("POST", "/me/links/myspace/start", nil)
+	req.SetPathValue("provider", "myspace")
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	StartLink(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestUnlink_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/me/links/discord", nil)
+	req.SetPathValue("provider", "discord")
+	w := httptest.NewRecorder()
+
+	Unlink(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestOAuthStart_UnknownProvider(t *testing.T) {
+	SetProviders(oauth.NewRegistry())
+	defer SetProviders(nil)
+
+	req := httptest.NewRequest("GET", "/auth/myspace", nil)
+	req.SetPathValue("provider", "myspace")
+	w := httptest.NewRecorder()
+
+	OAuthStart(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	cases := map[string]bool{
+		"halva":     true,
+		"dj.spin-z": true,
+		"a_b":       true,
+		"ab":        false,
+		"bad name!": false,
+		"émile":     false,
+	}
+	for name, ok := range cases {
+		if msg := validateUsername(name); (msg == "") != ok {
+			t.Errorf("validateUsername(%q) = %q, want ok=%v", name, msg, ok)
+		}
+	}
+}
+
+func TestSanitizeUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{"Already valid", "nelly", "nelly"},
+		{"Spaces and symbols", "Gina G ✨", "Gina_G"},
+		{"Too short", "x", "x_discord"},
+		{"Nothing usable", "✨✨", "discord"},
+		{"Too long", strings.Repeat("a", 40), strings.Repeat("a", 30)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := sanitizeUsername(tt.raw, "discord")
+			if result != tt.expected {
+				t.Errorf("sanitizeUsername(%q) = %q, want %q", tt.raw, result, tt.expected)
+			}
+			if msg := validateUsername(result); msg != "" {
+				t.Errorf("sanitized username %q is invalid: %s", result, msg)
+			}
+		})
+	}
+}
+
+func TestUsernameCandidates(t *testing.T) {
+	candidates := usernameCandidates(strings.Repeat("a", 30))
+
+	if candidates[0] != strings.Repeat("a", 30) {
+		t.Errorf("expected the base name first, got %q", candidates[0])
+	}
+	if candidates[1] != strings.Repeat("a", 28)+"_2" {
+		t.Errorf("expected a shortened numbered name, got %q", candidates[1])
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if msg := validateUsername(c); msg != "" {
+			t.Errorf("candidate %q is invalid: %s", c, msg)
+		}
+		if seen[c] {
+			t.Errorf("duplicate candidate %q", c)
+		}
+		seen[c] = true
+	}
+}
+
+func TestChooseUsername_Invalid(t *testing.T) {
+	body := strings.NewReader(`{"username":"no"}`)
+	req := httptest.NewRequest("POST", "/me/username", body)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	ChooseUsername(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	cases := map[string]bool{
+		"short":                 false,
+		"longenough":            true,
+		strings.Repeat("a", 72): true,
+		strings.Repeat("a", 73): false,
+	}
+	for password, ok := range cases {
+		if got := validatePassword(password) == ""; got != ok {
+			t.Errorf("validatePassword(%d chars) ok = %v, want %v", len(password), got, ok)
+		}
+	}
+}
+
+func TestChangePassword_Unauthorized(t *testing.T) {
+	body := strings.NewReader(`{"new_password":"longenough"}`)
+	req := httptest.NewRequest("POST", "/me/password", body)
+	w := httptest.NewRecorder()
+
+	ChangePassword(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestChangePassword_TooShort(t *testing.T) {
+	body := strings.NewReader(`{"current_password":"oldpassword","new_password":"short"}`)
+	req := httptest.NewRequest("POST", "/me/password", body)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	ChangePassword(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestSetEmail_Invalid(t *testing.T) {
+	body := strings.NewReader(`{"email":"not-an-email"}`)
+	req := httptest.NewRequest("POST", "/me/email", body)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	SetEmail(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestForgotPassword_InvalidEmail(t *testing.T) {
+	body := strings.NewReader(`{"email":""}`)
+	req := httptest.NewRequest("POST", "/auth/forgot", body)
+	w := httptest.NewRecorder()
+
+	ForgotPassword(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestResetPassword_MissingToken(t *testing.T) {
+	body := strings.NewReader(`{"new_password":"longenough"}`)
+	req := httptest.NewRequest("POST", "/auth/reset", body)
+	w := httptest.NewRecorder()
+
+	ResetPassword(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestVerifyEmail_MissingToken(t *testing.T) {
+	body := strings.NewReader(`{}`)
+	req := httptest.NewRequest("POST", "/auth/verify-email", body)
+	w := httptest.NewRecorder()
+
+	VerifyEmail(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	code, err := generateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 19 || strings.Count(code, "-") != 3 {
+		t.Errorf("unexpected recovery code format %q", code)
+	}
+	if normalizeRecoveryCode(strings.ToUpper(code)) != strings.ReplaceAll(code, "-", "") {
+		t.Errorf("expected normalization to ignore case and dashes for %q", code)
+	}
+}
+
+func TestEnableTOTP_MissingCode(t *testing.T) {
+	body := strings.NewReader(`{}`)
+	req := httptest.NewRequest("POST", "/me/2fa/enable", body)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	EnableTOTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestDisableTOTP_Unauthorized(t *testing.T) {
+	body := strings.NewReader(`{"code":"123456"}`)
+	req := httptest.NewRequest("POST", "/me/2fa/disable", body)
+	w := httptest.NewRecorder()
+
+	DisableTOTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestVerifyMFA_MissingFields(t *testing.T) {
+	body := strings.NewReader(`{"mfa_token":"abc"}`)
+	req := httptest.NewRequest("POST", "/auth/2fa", body)
+	w := httptest.NewRecorder()
+
+	VerifyMFA(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestValidateTokenRequest(t *testing.T) {
+	req := models.CreateAPITokenRequest{
+		Name:   "  bot  ",
+		Scopes: []string{middleware.ScopeSongsWrite, middleware.ScopeChainsRead, middleware.ScopeSongsWrite},
+	}
+	if msg := validateTokenRequest(&req); msg != "" {
+		t.Fatalf("unexpected error %q", msg)
+	}
+	if req.Name != "bot" || len(req.Scopes) != 2 {
+		t.Errorf("expected trimmed name and deduplicated scopes, got %q %v", req.Name, req.Scopes)
+	}
+
+	invalid := []models.CreateAPITokenRequest{
+		{Name: "", Scopes: []string{middleware.ScopeRead}},
+		{Name: "bot"},
+		{Name: "bot", Scopes: []string{middleware.ScopeAccount}},
+		{Name: "bot", Scopes: []string{"admin"}},
+		{Name: "bot", Scopes: []string{middleware.ScopeRead}, ExpiresInDays: 400},
+	}
+	for _, r := range invalid {
+		if validateTokenRequest(&r) == "" {
+			t.Errorf("expected %+v to be rejected", r)
+		}
+	}
+}
+
+func TestCreateAPIToken_RejectsAPITokens(t *testing.T) {
+	body := strings.NewReader(`{"name":"bot","scopes":["read"]}`)
+	req := httptest.NewRequest("POST", "/me/tokens", body)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	ctx = context.WithValue(ctx, middleware.ScopesKey, []string{middleware.ScopeRead})
+	req = req.WithContext(ctx)
+
+	CreateAPIToken(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
+func TestSubmitSong_MissingScope(t *testing.T) {
+	body := strings.NewReader(`{"url":"https://youtube.com/watch?v=abc"}`)
+	req := httptest.NewRequest("POST", "/songs", body)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	ctx = context.WithValue(ctx, middleware.ScopesKey, []string{middleware.ScopeRead})
+	req = req.WithContext(ctx)
+
+	SubmitSong(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := lockoutPolicy{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute}
+	cases := map[int]time.Duration{
+		1:   0,
+		5:   0,
+		6:   30 * time.Second,
+		7:   time.Minute,
+		8:   2 * time.Minute,
+		10:  8 * time.Minute,
+		11:  15 * time.Minute,
+		500: 15 * time.Minute,
+	}
+	for failures, want := range cases {
+		if got := policy.delay(failures); got != want {
+			t.Errorf("delay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestAttemptKeys(t *testing.T) {
+	if userAttemptKey("Halva") != userAttemptKey("halva") {
+		t.Error("expected username keys to ignore case")
+	}
+	if userAttemptKey("x") == ipAttemptKey("x") {
+		t.Error("expected username and IP keys not to collide")
+	}
+	if len(userAttemptKey(strings.Repeat("a", 500))) > 120 {
+		t.Error("expected long usernames to be truncated to fit the key column")
+	}
+}
+
+func TestWriteLockedOut(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeLockedOut(w, 1500*time.Millisecond)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected Retry-After 2, got %q", got)
+	}
+}
+
+func TestExportAccount_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("GET", "/me/export", nil)
+	w := httptest.NewRecorder()
+
+	ExportAccount(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestExportAccount_InvalidFormat(t *testing.T) {
+	req := httptest.NewRequest("GET", "/me/export?format=xml", nil)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	ExportAccount(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestDeleteAccount_InvalidChainPolicy(t *testing.T) {
+	body := strings.NewReader(`{"password":"password123","chains":"keep"}`)
+	req := httptest.NewRequest("DELETE", "/me", body)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+
+	DeleteAccount(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestDeleteAccount_RejectsAPITokens(t *testing.T) {
+	body := strings.NewReader(`{"password":"password123"}`)
+	req := httptest.NewRequest("DELETE", "/me", body)
+	w := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	ctx = context.WithValue(ctx, middleware.ScopesKey, []string{middleware.ScopeSongsWrite, middleware.ScopeChainsWrite})
+	req = req.WithContext(ctx)
+
+	DeleteAccount(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
+func TestValidateProfileUpdate(t *testing.T) {
+	name := "  Halva  "
+	empty := ""
+	req := models.UpdateProfileRequest{DisplayName: &name, AvatarURL: &empty}
+	if msg := validateProfileUpdate(&req); msg != "" {
+		t.Fatalf("unexpected error %q", msg)
+	}
+	if *req.DisplayName != "Halva" {
+		t.Errorf("expected display name to be trimmed, got %q", *req.DisplayName)
+	}
+
+	long := strings.Repeat("é", 301)
+	multiline := "two\nlines"
+	insecure := "http://example.com/me.png"
+	script := "javascript:alert(1)"
+	invalid := []models.UpdateProfileRequest{
+		{Bio: &long},
+		{DisplayName: &multiline},
+		{AvatarURL: &insecure},
+		{AvatarURL: &script},
+	}
+	for _, r := range invalid {
+		if validateProfileUpdate(&r) == "" {
+			t.Errorf("expected %+v to be rejected", r)
+		}
+	}
+}
+
+func TestUpdateProfile_Unauthorized(t *testing.T) {
+	body := strings.NewReader(`{"bio":"hi"}`)
+	req := httptest.NewRequest("PATCH", "/me", body)
+	w := httptest.NewRecorder()
+
+	UpdateProfile(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users/halva/songs?limit=51", nil)
+	w := httptest.NewRecorder()
+
+	if _, _, ok := parsePage(w, req, 20); ok || w.Code != http.StatusBadRequest {
+		t.Errorf("expected limit 51 to be rejected, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/users/halva/songs?offset=10", nil)
+	limit, offset, ok := parsePage(httptest.NewRecorder(), req, 20)
+	if !ok || limit != 20 || offset != 10 {
+		t.Errorf("expected 20/10, got %d/%d", limit, offset)
+	}
+}
+
+func TestValidateSwapSong(t *testing.T) {
+	songID := int64(7)
+	note := "   "
+	req := models.SwapSongRequest{SongID: &songID, Note: &note}
+	if msg := validateSwapSong(&req); msg != "" {
+		t.Fatalf("unexpected error %q", msg)
+	}
+	if req.Note != nil {
+		t.Errorf("expected a blank note to be dropped")
+	}
+
+	long := strings.Repeat("é", 201)
+	invalid := []models.SwapSongRequest{
+		{},
+		{SongID: &songID, URL: "https://example.com/song"},
+		{SongID: &songID, Note: &long},
+		{URL: "ftp://example.com/song"},
+	}
+	for _, r := range invalid {
+		if validateSwapSong(&r) == "" {
+			t.Errorf("expected %+v to be rejected", r)
+		}
+	}
+}
+
+func TestCreateSwap_Unauthorized(t *testing.T) {
+	body := strings.NewReader(`{"recipient":"halva","song_id":1}`)
+	req := httptest.NewRequest("POST", "/swaps", body)
+	w := httptest.NewRecorder()
+
+	CreateSwap(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestCreateSwap_MissingRecipient(t *testing.T) {
+	body := strings.NewReader(`{"song_id":1}`)
+	req := httptest.NewRequest("POST", "/swaps", body)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	CreateSwap(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestInbox_MissingScope(t *testing.T) {
+	req := httptest.NewRequest("GET", "/me/inbox", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	ctx = context.WithValue(ctx, middleware.ScopesKey, []string{middleware.ScopeChainsRead})
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	Inbox(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
+func TestReplySwap_InvalidID(t *testing.T) {
+	body := strings.NewReader(`{"song_id":1}`)
+	req := httptest.NewRequest("POST", "/swaps/abc/reply", body)
+	req.SetPathValue("id", "abc")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	ReplySwap(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestLiveStream_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("GET", "/live", nil)
+	w := httptest.NewRecorder()
+
+	LiveStream(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestLiveStream_AlreadyQueued(t *testing.T) {
+	player, err := liveMatcher.Join(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer liveMatcher.Leave(player)
+
+	req := httptest.NewRequest("GET", "/live", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(42))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	LiveStream(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", w.Code)
+	}
+}
+
+func TestSubmitLiveSong_NoMatch(t *testing.T) {
+	body := strings.NewReader(`{"song_id":1}`)
+	req := httptest.NewRequest("POST", "/live/matches/abc/song", body)
+	req.SetPathValue("id", "abc")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	SubmitLiveSong(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestSubmitLiveSong_MissingSong(t *testing.T) {
+	body := strings.NewReader(`{}`)
+	req := httptest.NewRequest("POST", "/live/matches/abc/song", body)
+	req.SetPathValue("id", "abc")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	SubmitLiveSong(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestParseEventTopics(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events?chain=3&chain=4&pool=true", nil)
+	topics, wantsUser, msg := parseEventTopics(req)
+	if msg != "" || wantsUser {
+		t.Fatalf("unexpected result %q, wantsUser=%v", msg, wantsUser)
+	}
+	if strings.Join(topics, ",") != "chain:3,chain:4,pool" {
+		t.Errorf("unexpected topics %v", topics)
+	}
+
+	for _, query := range []string{"", "?chain=abc", "?chain=0", "?pool=false"} {
+		req := httptest.NewRequest("GET", "/events"+query, nil)
+		if _, _, msg := parseEventTopics(req); msg == "" {
+			t.Errorf("expected %q to be rejected", query)
+		}
+	}
+}
+
+func TestEvents_MeUnauthorized(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events?me=true", nil)
+	w := httptest.NewRecorder()
+
+	Events(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestEvents_Resume(t *testing.T) {
+	first := eventBus.Publish(events.ChainTopic(999), events.SongAdded, models.Song{ID: 1})
+	second := eventBus.Publish(events.ChainTopic(999), events.SongAdded, models.Song{ID: 2})
+
+	req := httptest.NewRequest("GET", "/events?chain=999", nil)
+	req.Header.Set("Last-Event-ID", first.ID)
+	// Already cancelled, so the handler returns right after the replay
+	ctx, cancel := context.WithCancel(req.Context())
+	cancel()
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	Events(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "id: "+second.ID+"\nevent: song-added\n") {
+		t.Errorf("expected the missed event to be replayed, got %q", body)
+	}
+	if strings.Contains(body, "id: "+first.ID+"\n") {
+		t.Errorf("expected the already-seen event to be skipped, got %q", body)
+	}
+	if w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestEvents_ResyncUnknownID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events?pool=true", nil)
+	req.Header.Set("Last-Event-ID", "from-another-server")
+	ctx, cancel := context.WithCancel(req.Context())
+	cancel()
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	Events(w, req)
+
+	if !strings.Contains(w.Body.String(), "event: resync\n") {
+		t.Errorf("expected a resync event, got %q", w.Body.String())
+	}
+}
+
+func TestCreateRoom_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("POST", "/chains/1/rooms", nil)
+	req.SetPathValue("id", "1")
+	w := httptest.NewRecorder()
+
+	CreateRoom(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestGetRoom_NotFound(t *testing.T) {
+	req := httptest.NewRequest("GET", "/rooms/missing", nil)
+	req.SetPathValue("id", "missing")
+	w := httptest.NewRecorder()
+
+	GetRoom(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
+
+func TestRoomSocket_InvalidTicket(t *testing.T) {
+	req := httptest.NewRequest("GET", "/rooms/abc/ws?ticket=nope", nil)
+	req.SetPathValue("id", "abc")
+	w := httptest.NewRecorder()
+
+	RoomSocket(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestRoomSocket_ForeignOrigin(t *testing.T) {
+	req := httptest.NewRequest("GET", "/rooms/abc/ws?ticket=nope", nil)
+	req.SetPathValue("id", "abc")
+	req.Header.Set("Origin", "https://evil.example")
+	w := httptest.NewRecorder()
+
+	RoomSocket(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
+func TestRoomSocket_Join(t *testing.T) {
+	room, err := partyHub.Create(1, 500, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := partyHub.IssueTicket(room.ID, 500, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms/{id}/ws", RoomSocket)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/rooms/" + room.ID + "/ws?ticket=" + ticket.Ticket
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var msg struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != party.MsgWelcome {
+		t.Fatalf("expected a welcome, got %+v (%v)", msg, err)
+	}
+
+	conn.WriteJSON(party.Command{Type: party.CmdPing, ClientTime: 42})
+	for msg.Type != party.MsgPong {
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(msg.Data) != `{"client_time":42}` {
+		t.Errorf("unexpected pong %s", msg.Data)
+	}
+
+	// Ending the room closes the socket
+	conn.WriteJSON(party.Command{Type: party.CmdEnd})
+	for {
+		if err := conn.ReadJSON(&msg); err != nil {
+			break
+		}
+	}
+	if msg.Type != party.MsgClosed {
+		t.Errorf("expected the last message to be closed, got %q", msg.Type)
+	}
+}
+
+func TestAddReaction_Unsupported(t *testing.T) {
+	body := strings.NewReader(`{"emoji":"💩"}`)
+	req := httptest.NewRequest("POST", "/songs/1/reactions", body)
+	req.SetPathValue("id", "1")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	AddReaction(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestRemoveReaction_MissingScope(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/songs/1/reactions/🔥", nil)
+	req.SetPathValue("id", "1")
+	req.SetPathValue("emoji", "🔥")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	ctx = context.WithValue(ctx, middleware.ScopesKey, []string{middleware.ScopeSongsRead})
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	RemoveReaction(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
+func TestValidateComment(t *testing.T) {
+	cases := []struct {
+		body string
+		ok   bool
+	}{
+		{"  love this crumb  ", true},
+		{"   ", false},
+		{strings.Repeat("é", 500), true},
+		{strings.Repeat("a", 501), false},
+	}
+	for _, c := range cases {
+		req := models.CreateCommentRequest{Body: c.body}
+		if msg := validateComment(&req); (msg == "") != c.ok {
+			t.Errorf("validateComment(%.20q) = %q, want ok=%v", c.body, msg, c.ok)
+		}
+	}
+
+	req := models.CreateCommentRequest{Body: "  trimmed  "}
+	validateComment(&req)
+	if req.Body != "trimmed" {
+		t.Errorf("expected the body to be trimmed, got %q", req.Body)
+	}
+}
+
+func TestCreateComment_Empty(t *testing.T) {
+	body := strings.NewReader(`{"body":"  "}`)
+	req := httptest.NewRequest("POST", "/songs/1/comments", body)
+	req.SetPathValue("id", "1")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	CreateComment(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestListComments_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("GET", "/songs/1/comments", nil)
+	req.SetPathValue("id", "1")
+	w := httptest.NewRecorder()
+
+	ListComments(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestDeleteComment_InvalidID(t *testing.T) {
+	req := httptest.NewRequest("DELETE", "/comments/abc", nil)
+	req.SetPathValue("id", "abc")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	DeleteComment(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestReportComment_ReasonTooLong(t *testing.T) {
+	body := strings.NewReader(`{"reason":"` + strings.Repeat("a", 201) + `"}`)
+	req := httptest.NewRequest("POST", "/comments/1/report", body)
+	req.SetPathValue("id", "1")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	ReportComment(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestListNotifications_Unauthorized(t *testing.T) {
+	req := httptest.NewRequest("GET", "/me/notifications", nil)
+	w := httptest.NewRecorder()
+
+	ListNotifications(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+}
+
+func TestListNotifications_InvalidLimit(t *testing.T) {
+	req := httptest.NewRequest("GET", "/me/notifications?limit=500", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	ListNotifications(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestMarkNotificationsRead_MissingScope(t *testing.T) {
+	req := httptest.NewRequest("POST", "/me/notifications/read", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	ctx = context.WithValue(ctx, middleware.ScopesKey, []string{middleware.ScopeSongsRead})
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	MarkNotificationsRead(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+}
+
+func TestMarkNotificationsRead_TooMany(t *testing.T) {
+	ids := make([]int64, maxMarkRead+1)
+	body, _ := json.Marshal(models.MarkNotificationsReadRequest{IDs: ids})
+	req := httptest.NewRequest("POST", "/me/notifications/read", strings.NewReader(string(body)))
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	MarkNotificationsRead(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestWriteExportZip(t *testing.T) {
+	export := &models.Export{
+		Swaps: []models.ExportSwap{{ID: 1, Direction: "sent", With: "halva", Status: models.SwapPending}},
+	}
+	w := httptest.NewRecorder()
+
+	if err := writeExportZip(w, export); err != nil {
+		t.Fatal(err)
+	}
+
+	body := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"profile.json", "submissions.json", "swaps.json", "comments.json", "notifications.json"} {
+		if files[name] == nil {
+			t.Errorf("expected %s in the export", name)
+		}
+	}
+
+	rc, err := files["swaps.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var swaps []models.ExportSwap
+	if err := json.NewDecoder(rc).Decode(&swaps); err != nil {
+		t.Fatal(err)
+	}
+	if len(swaps) != 1 || swaps[0].With != "halva" {
+		t.Errorf("unexpected swaps %+v", swaps)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"abcdef", 3, "abc"},
+		// é is two bytes; cutting through it drops the whole character
+		{"abé", 3, "ab"},
+		{"日本語", 7, "日本"},
+		{"bad\xffbyte", 20, "badbyte"},
+	}
+	for _, c := range cases {
+		got := truncate(c.in, c.n)
+		if got != c.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", c.in, c.n, got, c.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) returned invalid UTF-8", c.in, c.n)
+		}
+	}
+
+	if key := userAttemptKey(strings.Repeat("é", 60)); !utf8.ValidString(key) {
+		t.Errorf("expected a valid attempt key, got %q", key)
+	}
+}
+
+func TestGetChain_InvalidID(t *testing.T) {
+	for _, id := range []string{"abc", "0", "-1"} {
+		req := httptest.NewRequest("GET", "/chains/"+id, nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+
+		GetChain(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %q, got %d", id, w.Code)
+		}
+	}
+}
+
+// useTestDB points the handlers at the database in TEST_DATABASE_URL, which
+// needs the migrations applied. Tests that use it are skipped without one.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = prev
+		db.Close()
+	})
+}
+
+// createTestUser adds a user who is deleted after the test, along with their
+// discoveries, songs and chains
+func createTestUser(t *testing.T) int64 {
+	t.Helper()
+	var id int64
+	err := database.DB.QueryRow(
+		"INSERT INTO users (username) VALUES ('test_' || md5(random()::text)) RETURNING id",
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		database.DB.Exec(`
+			DELETE FROM discoveries
+			WHERE user_id = $1 OR song_id IN (SELECT id FROM songs WHERE submitted_by = $1)
+		`, id)
+		database.DB.Exec("DELETE FROM songs WHERE submitted_by = $1", id)
+		database.DB.Exec("DELETE FROM chains WHERE created_by = $1", id)
+		database.DB.Exec("DELETE FROM users WHERE id = $1", id)
+	})
+	return id
+}
+
+func TestDiscover_SkipsPendingSwapSong(t *testing.T) {
+	useTestDB(t)
+	sender := createTestUser(t)
+	recipient := createTestUser(t)
+
+	crumb := "swapped before discovered"
+	songID, err := resolveSwapSong(database.DB, sender, models.SwapSongRequest{
+		URL:          "https://www.youtube.com/watch?v=swaptest",
+		ContextCrumb: &crumb,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.DB.Exec(`
+		INSERT INTO swaps (sender_id, recipient_id, song_id, expires_at)
+		VALUES ($1, $2, $3, NOW() + INTERVAL '7 days')
+	`, sender, recipient, songID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave the swap's song as the only one the recipient hasn't heard
+	_, err = database.DB.Exec(`
+		INSERT INTO discoveries (user_id, song_id)
+		SELECT $1, id FROM songs WHERE id <> $2
+	`, recipient, songID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/discover", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, recipient)
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	Discover(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+
+	resp, err := search.NewPostgres(database.DB).Search(context.Background(), search.Query{
+		Text:   crumb,
+		Kinds:  map[string]bool{models.SearchKindSong: true},
+		Limit:  50,
+		UserID: recipient,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range resp.Songs {
+		if result.ID == songID {
+			t.Errorf("expected the swap's song not to be searchable, got %+v", result)
+		}
+	}
+}
+
+func TestVerifyEmail_TakesAddressFromUnverifiedClaims(t *testing.T) {
+	useTestDB(t)
+	squatter := createTestUser(t)
+	owner := createTestUser(t)
+	email := "owner_" + strconv.FormatInt(owner, 10) + "@example.com"
+
+	// Someone else setting the address first mustn't stop the owner adding it
+	for _, userID := range []int64{squatter, owner} {
+		req := httptest.NewRequest("POST", "/me/email", strings.NewReader(`{"email": "`+email+`"}`))
+		ctx := context.WithValue(req.Context(), middleware.UserIDKey, userID)
+		req = req.WithContext(ctx)
+		w := httptest.NewRecorder()
+
+		SetEmail(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := createAccountToken(tx, owner, tokenPurposeVerifyEmail, &email, verifyEmailTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/auth/verify-email", strings.NewReader(`{"token": "`+token+`"}`))
+	w := httptest.NewRecorder()
+
+	VerifyEmail(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var squatterEmail *string
+	if err := database.DB.QueryRow("SELECT email FROM users WHERE id = $1", squatter).Scan(&squatterEmail); err != nil {
+		t.Fatal(err)
+	}
+	if squatterEmail != nil {
+		t.Errorf("expected the unverified claim to be cleared, got %q", *squatterEmail)
+	}
+}
+
+func TestFeed_InvalidLimit(t *testing.T) {
+	req := httptest.NewRequest("GET", "/me/feed?limit=0", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	Feed(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestFeed_PagesWithoutSkipping(t *testing.T) {
+	useTestDB(t)
+	owner := createTestUser(t)
+	follower := createTestUser(t)
+
+	var chainID int64
+	err := database.DB.QueryRow(
+		"INSERT INTO chains (name, created_by) VALUES ('feed test', $1) RETURNING id", owner,
+	).Scan(&chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = database.DB.Exec(`
+		INSERT INTO chain_follows (user_id, chain_id, last_seen_at)
+		VALUES ($1, $2, NOW() - INTERVAL '1 hour')
+	`, follower, chainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		song, err := insertSong(database.DB, owner, "https://www.youtube.com/watch?v=feed"+strconv.Itoa(i), nil, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = database.DB.Exec(`
+			INSERT INTO chain_songs (chain_id, song_id, added_by, added_at)
+			VALUES ($1, $2, $3, NOW() - make_interval(mins => $4))
+		`, chainID, song.ID, owner, 30-i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range []int{2, 1, 0} {
+		req := httptest.NewRequest("GET", "/me/feed?limit=2", nil)
+		ctx := context.WithValue(req.Context(), middleware.UserIDKey, follower)
+		req = req.WithContext(ctx)
+		w := httptest.NewRecorder()
+
+		Feed(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		var resp models.FeedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Items) != want {
+			t.Fatalf("expected %d items, got %d", want, len(resp.Items))
+		}
+	}
+}
+
+func TestLikeSong_InvalidID(t *testing.T) {
+	req := httptest.NewRequest("POST", "/songs/abc/like", nil)
+	req.SetPathValue("id", "abc")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, int64(1))
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	LikeSong(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+}
+
+func TestLikeSong_UndiscoveredSong(t *testing.T) {
+	useTestDB(t)
+	submitter := createTestUser(t)
+	liker := createTestUser(t)
+
+	song, err := insertSong(database.DB, submitter, "https://www.youtube.com/watch?v=liketest", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/songs/x/like", nil)
+	req.SetPathValue("id", strconv.FormatInt(song.ID, 10))
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, liker)
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	LikeSong(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", w.Code)
+	}
+
+	// Liking mustn't have opened the song's thread either
+	_, allowed, err := songParticipant(liker, song.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Error("expected the like not to record a discovery")
+	}
+}
+
+func TestRefreshChainScores_IgnoresForkedSongs(t *testing.T) {
+	useTestDB(t)
+	owner := createTestUser(t)
+	forker := createTestUser(t)
+
+	var sourceID int64
+	err := database.DB.QueryRow(
+		"INSERT INTO chains (name, created_by, created_at) VALUES ('trending test', $1, NOW() - INTERVAL '1 hour') RETURNING id", owner,
+	).Scan(&sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	song, err := insertSong(database.DB, owner, "https://www.youtube.com/watch?v=trendtest", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.DB.Exec(
+		"INSERT INTO chain_songs (chain_id, song_id, added_by) VALUES ($1, $2, $3)", sourceID, song.ID, owner,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/chains/x/fork", nil)
+	req.SetPathValue("id", strconv.FormatInt(sourceID, 10))
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, forker)
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	ForkChain(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var fork models.Chain
+	if err := json.NewDecoder(w.Body).Decode(&fork); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RefreshChainScores(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sourceScore, forkScore float64
+	err = database.DB.QueryRow(
+		"SELECT trending_score FROM chain_scores WHERE chain_id = $1", sourceID,
+	).Scan(&sourceScore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.DB.QueryRow(
+		"SELECT trending_score FROM chain_scores WHERE chain_id = $1", fork.ID,
+	).Scan(&forkScore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sourceScore <= 0 || forkScore != 0 {
+		t.Errorf("expected only the source chain to score, got source %f and fork %f", sourceScore, forkScore)
+	}
+}
+
+func TestGetChain_NotFound(t *testing.T) {
+	useTestDB(t)
+
+	var missing int64
+	if err := database.DB.QueryRow("SELECT COALESCE(MAX(id), 0) + 1 FROM chains").Scan(&missing); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/chains/x", nil)
+	req.SetPathValue("id", strconv.FormatInt(missing, 10))
+	w := httptest.NewRecorder()
+
+	GetChain(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+}
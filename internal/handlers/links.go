@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+// frontendURL is where OAuth callbacks send the browser back to
+func frontendURL(r *http.Request) string {
+	if u := os.Getenv("FRONTEND_URL"); u != "" {
+		return u
+	}
+	// Fallback: assume same origin (works behind Nginx)
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+// StartLink begins an OAuth flow that links a provider to the signed-in user.
+// It returns the provider URL instead of redirecting, since it is called with fetch.
+func StartLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	provider, err := Providers.Get(r.PathValue("provider"))
+	if err != nil {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	authURL, err := providerAuthURL(w, r, provider, &userID)
+	if err != nil {
+		http.Error(w, "Failed to start link", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.LinkStartResponse{URL: authURL})
+}
+
+// completeLink attaches a provider account to an existing user and sends the browser back
+func completeLink(w http.ResponseWriter, r *http.Request, provider, providerUserID, providerUsername string, sessionKey *string, userID int64) {
+	redirect := func(fragment string) {
+		http.Redirect(w, r, frontendURL(r)+"/#"+fragment, http.StatusTemporaryRedirect)
+	}
+
+	// The provider account may already belong to someone
+	var ownerID int64
+	err := database.DB.QueryRow(
+		`SELECT user_id FROM linked_accounts WHERE provider = $1 AND provider_user_id = $2`,
+		provider, providerUserID,
+	).Scan(&ownerID)
+	if err == nil && ownerID != userID {
+		redirect("link_error=already_linked&provider=" + url.QueryEscape(provider))
+		return
+	} else if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Relinking replaces whatever account of this provider the user had before
+	_, err = database.DB.Exec(`
+		INSERT INTO linked_accounts (user_id, provider, provider_user_id, provider_username, session_key)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, provider) DO UPDATE SET
+			provider_user_id = EXCLUDED.provider_user_id,
+			provider_username = EXCLUDED.provider_username,
+			session_key = EXCLUDED.session_key,
+			linked_at = NOW()
+	`, userID, provider, providerUserID, providerUsername, sessionKey)
+	if err != nil {
+		http.Error(w, "Failed to link account", http.StatusInternalServerError)
+		return
+	}
+
+	redirect("linked=" + url.QueryEscape(provider))
+}
+
+// ListLinks returns the providers linked to the signed-in user
+func ListLinks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT provider, provider_username, linked_at
+		FROM linked_accounts
+		WHERE user_id = $1
+		ORDER BY linked_at
+	`, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch linked accounts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	links := []models.LinkedAccount{}
+	for rows.Next() {
+		var l models.LinkedAccount
+		if err := rows.Scan(&l.Provider, &l.ProviderUsername, &l.LinkedAt); err != nil {
+			continue
+		}
+		links = append(links, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// Unlink removes a provider from the signed-in user, as long as they can still log in afterwards
+func Unlink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	provider := r.PathValue("provider")
+	if provider == "" {
+		http.Error(w, "Provider required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the user row so two concurrent unlinks can't both pass the guard
+	var hasPassword bool
+	err = tx.QueryRow(
+		`SELECT password_hash IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`, userID,
+	).Scan(&hasPassword)
+	if err != nil {
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+
+	var otherLinks int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM linked_accounts WHERE user_id = $1 AND provider <> $2`, userID, provider,
+	).Scan(&otherLinks)
+	if err != nil {
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+
+	if !hasPassword && otherLinks == 0 {
+		http.Error(w, "Can't remove your last login method", http.StatusConflict)
+		return
+	}
+
+	result, err := tx.Exec(
+		`DELETE FROM linked_accounts WHERE user_id = $1 AND provider = $2`, userID, provider,
+	)
+	if err != nil {
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		http.Error(w, "Provider not linked", http.StatusNotFound)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"unlinked": true}`))
+}
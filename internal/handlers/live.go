@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/live"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+// liveMatcher pairs users for live swaps. It lives in this process, so live
+// swaps only pair users connected to the same API instance.
+var liveMatcher = newLiveMatcher()
+
+func newLiveMatcher() *live.Matcher {
+	m := live.NewMatcher(live.DefaultTimeout)
+	m.OnReveal = recordLiveSwap
+	return m
+}
+
+// recordLiveSwap delivers each side's song to their partner as a discovery
+func recordLiveSwap(reveal live.Reveal) {
+	_, err := database.DB.Exec(`
+		INSERT INTO discoveries (user_id, song_id)
+		VALUES ($1, $2), ($3, $4)
+		ON CONFLICT (user_id, song_id) DO NOTHING
+	`, reveal.Users[0], reveal.Songs[1].ID, reveal.Users[1], reveal.Songs[0].ID)
+	if err != nil {
+		log.Println("Failed to record live swap", reveal.MatchID+":", err)
+	}
+}
+
+// LiveStream queues the authenticated user for an anonymous live swap and
+// streams queued, matched, reveal, timeout and partner_left events until the
+// round ends or the client disconnects
+func LiveStream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	player, err := liveMatcher.Join(userID)
+	if errors.Is(err, live.ErrAlreadyQueued) {
+		http.Error(w, "You're already in a live swap", http.StatusConflict)
+		return
+	}
+	defer liveMatcher.Leave(player)
+
+	flusher, ok := startSSE(w)
+	if !ok {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if writeSSEHeartbeat(w) != nil {
+				return
+			}
+		case ev, ok := <-player.Events():
+			if !ok {
+				return
+			}
+			if writeSSE(w, ev.Type, "", ev) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// SubmitLiveSong picks the authenticated user's song for a live match
+func SubmitLiveSong(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	var req models.LiveSongRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Live swaps are anonymous, so there's no note to give anything away
+	songReq := models.SwapSongRequest{SongID: req.SongID, URL: req.URL, ContextCrumb: req.ContextCrumb}
+	if msg := validateSwapSong(&songReq); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// Checked before the song is saved so a late submission doesn't save one nobody gets
+	matchID := r.PathValue("id")
+	if !liveSubmitAllowed(w, liveMatcher.Check(userID, matchID)) {
+		return
+	}
+
+	songID, err := resolveSwapSong(database.DB, userID, songReq)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save song", http.StatusInternalServerError)
+		return
+	}
+
+	var song models.Song
+	err = database.DB.QueryRow(`
+		SELECT id, url, platform, context_crumb, created_at FROM songs WHERE id = $1
+	`, songID).Scan(&song.ID, &song.URL, &song.Platform, &song.ContextCrumb, &song.CreatedAt)
+	if err != nil {
+		http.Error(w, "Failed to fetch song", http.StatusInternalServerError)
+		return
+	}
+
+	if !liveSubmitAllowed(w, liveMatcher.Submit(userID, matchID, song)) {
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// liveSubmitAllowed answers for a matcher error, returning false if there was one
+func liveSubmitAllowed(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, live.ErrNoMatch):
+		http.Error(w, "Match not found or already over", http.StatusNotFound)
+	case errors.Is(err, live.ErrAlreadySubmitted):
+		http.Error(w, "You already picked a song for this match", http.StatusConflict)
+	default:
+		http.Error(w, "Failed to submit song", http.StatusInternalServerError)
+	}
+	return false
+}
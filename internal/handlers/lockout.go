@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// lockoutPolicy describes when repeated failures start locking a key out
+type lockoutPolicy struct {
+	// FreeAttempts are allowed before any lockout
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+var (
+	// Per username: a handful of typos, then 30s, 1m, 2m... up to 15 minutes
+	userLockout = lockoutPolicy{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute}
+	// Per IP: more slack, since many people can share one address
+	ipLockout = lockoutPolicy{FreeAttempts: 20, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute}
+)
+
+// loginFailureWindow is how long failures are remembered. A failure after a
+// quiet period this long starts counting from one again.
+const loginFailureWindow = time.Hour
+
+// delay returns how long a key is locked after its nth consecutive failure
+func (p lockoutPolicy) delay(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	// Cap the exponent well before it could overflow
+	if over > 30 {
+		return p.MaxDelay
+	}
+	d := p.BaseDelay * time.Duration(1<<(over-1))
+	if d > p.MaxDelay || d <= 0 {
+		return p.MaxDelay
+	}
+	return d
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + truncate(strings.ToLower(username), 100)
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + truncate(ip, 100)
+}
+
+// loginLockedFor returns how long until the username or IP may try again, or 0
+func loginLockedFor(username, ip string) (time.Duration, error) {
+	var lockedUntil *time.Time
+	err := database.DB.QueryRow(`
+		SELECT MAX(locked_until) FROM login_attempts
+		WHERE key IN ($1, $2) AND locked_until > NOW()
+	`, userAttemptKey(username), ipAttemptKey(ip)).Scan(&lockedUntil)
+	if err != nil || lockedUntil == nil {
+		return 0, err
+	}
+	return time.Until(*lockedUntil), nil
+}
+
+// recordLoginFailure counts a failed attempt against the username and the IP
+// and locks either one out once it has used up its free attempts
+func recordLoginFailure(username, ip string) error {
+	for key, policy := range map[string]lockoutPolicy{
+		userAttemptKey(username): userLockout,
+		ipAttemptKey(ip):         ipLockout,
+	} {
+		var failures int
+		err := database.DB.QueryRow(`
+			INSERT INTO login_attempts (key, failures, last_failure_at)
+			VALUES ($1, 1, NOW())
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE
+					WHEN login_attempts.last_failure_at < NOW() - $2 * INTERVAL '1 second' THEN 1
+					ELSE login_attempts.failures + 1
+				END,
+				last_failure_at = NOW()
+			RETURNING failures
+		`, key, loginFailureWindow.Seconds()).Scan(&failures)
+		if err != nil {
+			return err
+		}
+
+		if d := policy.delay(failures); d > 0 {
+			_, err = database.DB.Exec(`
+				UPDATE login_attempts SET locked_until = $1 WHERE key = $2
+			`, time.Now().Add(d), key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	database.DB.Exec(`
+		DELETE FROM login_attempts
+		WHERE last_failure_at < NOW() - INTERVAL '1 day'
+		AND (locked_until IS NULL OR locked_until < NOW())
+	`)
+	return nil
+}
+
+// clearLoginFailures forgets a username's failures after a successful login.
+// The IP's count is left to expire, or logging into your own account would
+// let you reset it between guesses at someone else's.
+func clearLoginFailures(username string) error {
+	_, err := database.DB.Exec(`DELETE FROM login_attempts WHERE key = $1`, userAttemptKey(username))
+	return err
+}
+
+// writeLockedOut answers 429 with a Retry-After in whole seconds
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// burnPasswordCheck runs a bcrypt comparison that always fails, so a login for
+// an unknown or passwordless account takes as long as a wrong password
+func burnPasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("songswap-dummy-password"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
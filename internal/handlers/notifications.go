@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/events"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/lib/pq"
+)
+
+// maxMarkRead is how many notifications can be marked read by ID at once
+const maxMarkRead = 100
+
+// notify tells a song's submitter that something happened to it, folding it
+// into their unread notification of the same kind if there is one. Nothing is
+// sent for the submitter's own actions or for songs without a submitter. It's
+// best effort: a failure is logged, never returned to the user who acted.
+func notify(kind string, songID int64, chainID *int64, actorID int64) {
+	var n models.Notification
+	var userID int64
+	err := database.DB.QueryRow(`
+		WITH n AS (
+			INSERT INTO notifications (user_id, kind, song_id, chain_id)
+			SELECT submitted_by, $1::varchar, id, $3::integer
+			FROM songs
+			WHERE id = $2 AND submitted_by IS NOT NULL AND submitted_by <> $4
+			ON CONFLICT (user_id, kind, song_id, COALESCE(chain_id, 0)) WHERE read_at IS NULL
+			DO UPDATE SET count = notifications.count + 1, updated_at = NOW()
+			RETURNING id, user_id, song_id, chain_id, count, created_at, updated_at
+		)
+		SELECT n.id, n.user_id, n.count, n.created_at, n.updated_at,
+			s.id, s.url, s.platform, s.context_crumb, s.created_at, c.id, c.name
+		FROM n
+		JOIN songs s ON n.song_id = s.id
+		LEFT JOIN chains c ON n.chain_id = c.id
+	`, kind, songID, chainID, actorID).Scan(&n.ID, &userID, &n.Count, &n.CreatedAt, &n.UpdatedAt,
+		&n.Song.ID, &n.Song.URL, &n.Song.Platform, &n.Song.ContextCrumb, &n.Song.CreatedAt, &n.ChainID, &n.ChainName)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		log.Println("Failed to record notification:", err)
+		return
+	}
+
+	n.Kind = kind
+	eventBus.Publish(events.UserTopic(userID), events.Notification, n)
+}
+
+func countUnreadNotifications(userID int64) (int, error) {
+	var unread int
+	err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID,
+	).Scan(&unread)
+	return unread, err
+}
+
+// ListNotifications returns the user's notifications, most recently updated
+// first, with the number still unread. ?unread=true leaves out read ones.
+func ListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsRead) {
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r, 20)
+	if !ok {
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	rows, err := database.DB.Query(`
+		SELECT n.id, n.kind, n.count, n.read_at IS NOT NULL, n.created_at, n.updated_at,
+			s.id, s.url, s.platform, s.context_crumb, s.created_at, c.id, c.name
+		FROM notifications n
+		JOIN songs s ON n.song_id = s.id
+		LEFT JOIN chains c ON n.chain_id = c.id
+		WHERE n.user_id = $1 AND (NOT $2 OR n.read_at IS NULL)
+		ORDER BY n.updated_at DESC, n.id DESC
+		LIMIT $3 OFFSET $4
+	`, userID, unreadOnly, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list := models.NotificationList{Notifications: []models.Notification{}}
+	for rows.Next() {
+		var n models.Notification
+		err := rows.Scan(&n.ID, &n.Kind, &n.Count, &n.Read, &n.CreatedAt, &n.UpdatedAt,
+			&n.Song.ID, &n.Song.URL, &n.Song.Platform, &n.Song.ContextCrumb, &n.Song.CreatedAt, &n.ChainID, &n.ChainName)
+		if err != nil {
+			continue
+		}
+		list.Notifications = append(list.Notifications, n)
+	}
+
+	list.Unread, err = countUnreadNotifications(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// MarkNotificationsRead marks some or all of the user's notifications read.
+// New activity on a song then starts a fresh notification.
+func MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	var req models.MarkNotificationsReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.IDs) > maxMarkRead {
+		http.Error(w, "At most 100 notifications can be marked at once", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if len(req.IDs) == 0 {
+		_, err = database.DB.Exec(`
+			UPDATE notifications SET read_at = NOW()
+			WHERE user_id = $1 AND read_at IS NULL
+		`, userID)
+	} else {
+		_, err = database.DB.Exec(`
+			UPDATE notifications SET read_at = NOW()
+			WHERE user_id = $1 AND read_at IS NULL AND id = ANY($2)
+		`, userID, pq.Array(req.IDs))
+	}
+	if err != nil {
+		http.Error(w, "Failed to mark notifications read", http.StatusInternalServerError)
+		return
+	}
+
+	unread, err := countUnreadNotifications(userID)
+	if err != nil {
+		http.Error(w, "Failed to mark notifications read", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"unread": unread})
+}
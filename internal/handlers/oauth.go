@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/oauth"
+)
+
+var Providers *oauth.Registry
+
+func SetProviders(providers *oauth.Registry) {
+	Providers = providers
+}
+
+// OAuthStart redirects the user to the provider's login page
+func OAuthStart(w http.ResponseWriter, r *http.Request) {
+	provider, err := Providers.Get(r.PathValue("provider"))
+	if err != nil {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	authURL, err := providerAuthURL(w, r, provider, nil)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// providerAuthURL starts a flow for this browser and returns the provider URL to send it to
+func providerAuthURL(w http.ResponseWriter, r *http.Request, provider oauth.Provider, linkUserID *int64) (string, error) {
+	state, challenge, err := beginOAuthFlow(w, r, provider.Name(), provider.PKCE(), linkUserID)
+	if err != nil {
+		return "", err
+	}
+	return provider.AuthURL(state, challenge)
+}
+
+// OAuthCallback is the shared callback pipeline: verify state, ask the provider
+// who the user is, then either link the account or log in (creating a user if needed)
+func OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider, err := Providers.Get(r.PathValue("provider"))
+	if err != nil {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+	name := provider.Name()
+
+	// Make sure this callback belongs to a flow this browser started
+	flow, err := finishOAuthFlow(w, r, name)
+	if err != nil {
+		http.Error(w, "Invalid or expired login attempt", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), r.URL.Query(), flow.CodeVerifier)
+	if err != nil {
+		log.Printf("OAuth %s exchange error: %v", name, err)
+		http.Error(w, "Login with "+name+" failed", http.StatusUnauthorized)
+		return
+	}
+
+	// A signed-in user is linking the provider to their account
+	if flow.LinkUserID != nil {
+		completeLink(w, r, name, identity.ProviderUserID, identity.ProviderUsername, identity.SessionKey, *flow.LinkUserID)
+		return
+	}
+
+	userID, err := loginWithIdentity(name, identity)
+	if err != nil {
+		log.Printf("OAuth %s login error: %v", name, err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+
+	// Hand the browser a one-time code rather than the tokens themselves,
+	// so nothing reusable ends up in history or logs
+	code, err := createLoginCode(userID, name)
+	if err != nil {
+		http.Error(w, "Failed to create login code", http.StatusInternalServerError)
+		return
+	}
+
+	redirectURL := fmt.Sprintf("%s/#code=%s", frontendURL(r), url.QueryEscape(code))
+	http.Redirect(w, r, redirectURL, http.StatusTemporaryRedirect)
+}
+
+// loginWithIdentity finds the user linked to a provider identity, creating one on first login
+func loginWithIdentity(provider string, identity oauth.Identity) (int64, error) {
+	// Check if this account is already linked
+	var userID int64
+	err := database.DB.QueryRow(
+		`SELECT user_id FROM linked_accounts WHERE provider = $1 AND provider_user_id = $2`,
+		provider, identity.ProviderUserID,
+	).Scan(&userID)
+
+	if err == nil {
+		// Existing linked account — refresh what the provider told us
+		_, err = database.DB.Exec(`
+			UPDATE linked_accounts
+			SET provider_username = $1, session_key = COALESCE($2, session_key)
+			WHERE provider = $3 AND provider_user_id = $4
+		`, identity.ProviderUsername, identity.SessionKey, provider, identity.ProviderUserID)
+		return userID, err
+	} else if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	// New user — create account from the provider username, no password
+	userID, err = createOAuthUser(identity.ProviderUsername, provider)
+	if err != nil {
+		return 0, err
+	}
+
+	// Link the account
+	_, err = database.DB.Exec(
+		`INSERT INTO linked_accounts (user_id, provider, provider_user_id, provider_username, session_key)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		userID, provider, identity.ProviderUserID, identity.ProviderUsername, identity.SessionKey,
+	)
+	return userID, err
+}
+
+// ListProviders returns the names of the configured login providers, for the login page
+func ListProviders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Providers.Names())
+}
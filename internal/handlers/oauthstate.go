@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+)
+
+const (
+	oauthStateTTL    = 10 * time.Minute
+	oauthStateCookie = "songswap_oauth"
+)
+
+var errInvalidState = errors.New("invalid OAuth state")
+
+var oauthStateSecret []byte
+
+// SetOAuthStateSecret sets the key OAuth state values are signed with.
+// Without one, a random key is generated, so states only survive this process.
+func SetOAuthStateSecret(secret []byte) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	oauthStateSecret = secret
+}
+
+// oauthFlow is what a verified callback gets back from its state
+type oauthFlow struct {
+	CodeVerifier string
+	// LinkUserID is set when a signed-in user started the flow to link the provider
+	LinkUserID *int64
+}
+
+// beginOAuthFlow binds a new OAuth flow to this browser and returns the state
+// to send to the provider. If pkce is set, it also returns a PKCE code challenge.
+// A non-nil linkUserID makes the callback link the provider instead of logging in.
+func beginOAuthFlow(w http.ResponseWriter, r *http.Request, provider string, pkce bool, linkUserID *int64) (state, challenge string, err error) {
+	binding, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	var verifier *string
+	if pkce {
+		v, err := randomToken()
+		if err != nil {
+			return "", "", err
+		}
+		verifier = &v
+		challenge = pkceChallenge(v)
+	}
+
+	expires := time.Now().Add(oauthStateTTL)
+	_, err = database.DB.Exec(`
+		INSERT INTO oauth_states (nonce, provider, code_verifier, expires_at, link_user_id)
+		VALUES ($1, $2, $3, $4, $5)
+	`, nonce, provider, verifier, expires, linkUserID)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Opportunistically clear out abandoned flows
+	database.DB.Exec(`DELETE FROM oauth_states WHERE expires_at < NOW()`)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    binding,
+		Path:     "/",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return signState(provider, nonce, expires, binding), challenge, nil
+}
+
+// finishOAuthFlow checks the state against the browser cookie and consumes it
+func finishOAuthFlow(w http.ResponseWriter, r *http.Request, provider string) (oauthFlow, error) {
+	// The cookie is single-use along with the state
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return oauthFlow{}, errInvalidState
+	}
+
+	nonce, err := verifyState(r.URL.Query().Get("state"), provider, cookie.Value, time.Now())
+	if err != nil {
+		return oauthFlow{}, err
+	}
+
+	var flow oauthFlow
+	var verifier sql.NullString
+	err = database.DB.QueryRow(`
+		DELETE FROM oauth_states
+		WHERE nonce = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING code_verifier, link_user_id
+	`, nonce, provider).Scan(&verifier, &flow.LinkUserID)
+	if err == sql.ErrNoRows {
+		return oauthFlow{}, errInvalidState
+	} else if err != nil {
+		return oauthFlow{}, err
+	}
+
+	flow.CodeVerifier = verifier.String
+	return flow, nil
+}
+
+// signState produces nonce.expiry.signature, where the signature also covers
+// the provider and the browser binding cookie
+func signState(provider, nonce string, expires time.Time, binding string) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return nonce + "." + exp + "." + stateSignature(provider, nonce, exp, binding)
+}
+
+// verifyState checks a state's signature and expiry and returns its nonce
+func verifyState(state, provider, binding string, now time.Time) (string, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 {
+		return "", errInvalidState
+	}
+	nonce, exp, sig := parts[0], parts[1], parts[2]
+
+	expected := stateSignature(provider, nonce, exp, binding)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return "", errInvalidState
+	}
+
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.After(time.Unix(expUnix, 0)) {
+		return "", errInvalidState
+	}
+	return nonce, nil
+}
+
+func stateSignature(provider, nonce, exp, binding string) string {
+	mac := hmac.New(sha256.New, oauthStateSecret)
+	fmt.Fprintf(mac, "%s|%s|%s|%s", provider, nonce, exp, binding)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// pkceChallenge is the S256 code challenge for a verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
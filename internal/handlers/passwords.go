@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/mail"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	tokenPurposeReset       = "reset"
+	tokenPurposeVerifyEmail = "verify_email"
+
+	resetTokenTTL       = time.Hour
+	verifyEmailTokenTTL = 24 * time.Hour
+)
+
+var Mailer mail.Mailer = mail.NewLog("songswap <noreply@songswap.local>")
+
+func SetMailer(m mail.Mailer) {
+	Mailer = m
+}
+
+// validatePassword returns a user-facing error message, or "" if the password is acceptable
+func validatePassword(password string) string {
+	if len(password) < 8 {
+		return "Password must be at least 8 characters"
+	}
+	// bcrypt ignores everything past 72 bytes
+	if len(password) > 72 {
+		return "Password must be under 72 characters"
+	}
+	return ""
+}
+
+// createAccountToken stores a single-use token for a mailed link
+func createAccountToken(tx *sql.Tx, userID int64, purpose string, email *string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO account_tokens (token_hash, user_id, purpose, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, hashToken(token), userID, purpose, email, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendMail delivers in the background so response times don't reveal whether
+// an address is registered, and a slow relay doesn't hold up the request
+func sendMail(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := Mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %q mail: %v", msg.Subject, err)
+		}
+	}()
+}
+
+// ChangePassword sets a new password. Accounts that already have one must
+// confirm it; OAuth-only accounts can add one this way. Every other session is signed out.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	sessionID, _ := r.Context().Value(middleware.SessionIDKey).(int64)
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validatePassword(req.NewPassword); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var currentHash sql.NullString
+	err = tx.QueryRow(
+		`SELECT password_hash FROM users WHERE id = $1 FOR UPDATE`, userID,
+	).Scan(&currentHash)
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	// 403 rather than 401: the token is fine, the password isn't
+	if currentHash.Valid {
+		if err := bcrypt.CompareHashAndPassword([]byte(currentHash.String), []byte(req.CurrentPassword)); err != nil {
+			http.Error(w, "Current password is incorrect", http.StatusForbidden)
+			return
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hash), userID); err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+	`, userID, sessionID); err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2`, userID, tokenPurposeReset,
+	); err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"password_changed": true}`))
+}
+
+// GetEmail returns the authenticated user's email and whether it is verified
+func GetEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var status models.EmailStatus
+	err := database.DB.QueryRow(
+		`SELECT email, email_verified_at IS NOT NULL FROM users WHERE id = $1`, userID,
+	).Scan(&status.Email, &status.Verified)
+	if err != nil {
+		http.Error(w, "Failed to fetch email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// SetEmail stores a new, unverified email and mails a verification link to it.
+// Whether anyone else has the address is only checked once it's verified, so
+// this doesn't reveal which addresses are registered.
+func SetEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.SetEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if !mail.ValidAddress(email) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to set email", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET email = $1, email_verified_at = NULL WHERE id = $2
+	`, email, userID)
+	if err != nil {
+		http.Error(w, "Failed to set email", http.StatusInternalServerError)
+		return
+	}
+
+	// Links sent to an earlier address must stop working
+	if _, err := tx.Exec(
+		`DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2`, userID, tokenPurposeVerifyEmail,
+	); err != nil {
+		http.Error(w, "Failed to set email", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := createAccountToken(tx, userID, tokenPurposeVerifyEmail, &email, verifyEmailTokenTTL)
+	if err != nil {
+		http.Error(w, "Failed to set email", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to set email", http.StatusInternalServerError)
+		return
+	}
+
+	sendMail(mail.Message{
+		To:      email,
+		Subject: "Verify your songswap email",
+		Body: "Open this link to verify your email address:\n\n" +
+			frontendURL(r) + "/#verify_email=" + url.QueryEscape(token) + "\n\n" +
+			"The link expires in 24 hours. If you didn't add this address, you can ignore this email.\n",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.EmailStatus{Email: &email, Verified: false})
+}
+
+// VerifyEmail consumes a verification token. It doesn't require a session,
+// since the link may be opened in a different browser. Other accounts that
+// set the same address without verifying it lose it.
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var email string
+	err = tx.QueryRow(`
+		DELETE FROM account_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND expires_at > NOW()
+		RETURNING user_id, email
+	`, hashToken(req.Token), tokenPurposeVerifyEmail).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := tx.Exec(`
+		UPDATE users SET email_verified_at = NOW()
+		WHERE id = $1 AND LOWER(email) = LOWER($2)
+	`, userID, email)
+	if database.IsUniqueViolation(err) {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		http.Error(w, "Email has changed since this link was sent", http.StatusBadRequest)
+		return
+	}
+
+	// The address is proven to be this user's now, so drop anyone else's claim on it
+	if _, err := tx.Exec(`
+		UPDATE users SET email = NULL
+		WHERE LOWER(email) = LOWER($1) AND id <> $2 AND email_verified_at IS NULL
+	`, email, userID); err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM account_tokens
+		WHERE purpose = $1 AND LOWER(email) = LOWER($2) AND user_id <> $3
+	`, tokenPurposeVerifyEmail, email, userID); err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"verified": true}`))
+}
+
+// ForgotPassword mails a reset link to a verified address. It answers the
+// same way whether or not the address belongs to anyone.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if !mail.ValidAddress(email) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	// Only verified addresses: an unverified one may have been mistyped into someone else's inbox
+	var userID int64
+	var address string
+	err := database.DB.QueryRow(`
+		SELECT id, email FROM users
+		WHERE LOWER(email) = LOWER($1) AND email_verified_at IS NOT NULL
+	`, email).Scan(&userID, &address)
+
+	if err == nil {
+		if token, err := issueResetToken(userID); err != nil {
+			log.Println("ForgotPassword token error:", err)
+		} else {
+			sendMail(mail.Message{
+				To:      address,
+				Subject: "Reset your songswap password",
+				Body: "Open this link to choose a new password:\n\n" +
+					frontendURL(r) + "/#reset_token=" + url.QueryEscape(token) + "\n\n" +
+					"The link expires in an hour. If you didn't ask for this, you can ignore this email.\n",
+			})
+		}
+	} else if err != sql.ErrNoRows {
+		log.Println("ForgotPassword DB error:", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"sent": true}`))
+}
+
+// issueResetToken replaces any outstanding reset token for the user
+func issueResetToken(userID int64) (string, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2`, userID, tokenPurposeReset,
+	); err != nil {
+		return "", err
+	}
+
+	token, err := createAccountToken(tx, userID, tokenPurposeReset, nil, resetTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// ResetPassword consumes a reset token, sets the new password and signs out every session
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validatePassword(req.NewPassword); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRow(`
+		DELETE FROM account_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND expires_at > NOW()
+		RETURNING user_id
+	`, hashToken(req.Token), tokenPurposeReset).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, string(hash), userID); err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID); err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"password_reset": true}`))
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 300
+	maxAvatarURLLength   = 500
+)
+
+// parsePage reads ?limit= (1-50) and ?offset=, answering 400 if either is malformed
+func parsePage(w http.ResponseWriter, r *http.Request, defaultLimit int) (limit, offset int, ok bool) {
+	limit = defaultLimit
+	query := r.URL.Query()
+
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > 50 {
+			http.Error(w, "Limit must be between 1 and 50", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		limit = n
+	}
+
+	if o := query.Get("offset"); o != "" {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 {
+			http.Error(w, "Offset must be a non-negative number", http.StatusBadRequest)
+			return 0, 0, false
+		}
+		offset = n
+	}
+
+	return limit, offset, true
+}
+
+// loadProfile builds a user's public profile
+func loadProfile(userID int64) (models.Profile, error) {
+	var p models.Profile
+	err := database.DB.QueryRow(`
+		SELECT u.username, u.display_name, u.bio, u.avatar_url, u.created_at,
+			u.show_submissions, u.show_likes,
+			(SELECT COUNT(*) FROM songs WHERE submitted_by = u.id AND in_pool),
+			(SELECT COUNT(*) FROM chains WHERE created_by = u.id),
+			(SELECT COUNT(*) FROM discoveries d JOIN songs s ON d.song_id = s.id
+				WHERE s.submitted_by = u.id AND d.liked = true)
+		FROM users u
+		WHERE u.id = $1
+	`, userID).Scan(&p.Username, &p.DisplayName, &p.Bio, &p.AvatarURL, &p.JoinedAt,
+		&p.ShowSubmissions, &p.ShowLikes, &p.SubmissionCount, &p.ChainCount, &p.LikesReceived)
+	return p, err
+}
+
+// lookupProfileUser resolves {username} and the user's privacy settings, answering 404 if unknown
+func lookupProfileUser(w http.ResponseWriter, r *http.Request) (userID int64, showSubmissions, showLikes bool, ok bool) {
+	err := database.DB.QueryRow(`
+		SELECT id, show_submissions, show_likes FROM users WHERE username = $1
+	`, r.PathValue("username")).Scan(&userID, &showSubmissions, &showLikes)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return 0, false, false, false
+	} else if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return 0, false, false, false
+	}
+	return userID, showSubmissions, showLikes, true
+}
+
+// GetProfile returns a user's public profile
+func GetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, _, _, ok := lookupProfileUser(w, r)
+	if !ok {
+		return
+	}
+
+	profile, err := loadProfile(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// GetProfileSongs lists the songs a user submitted to the pool, if they made
+// that public. Songs they sent in swaps are left out.
+func GetProfileSongs(w http.ResponseWriter, r *http.Request) {
+	userID, showSubmissions, _, ok := lookupProfileUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !showSubmissions {
+		http.Error(w, "This user's submissions are private", http.StatusForbidden)
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r, 20)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, url, platform, context_crumb, created_at
+		FROM songs
+		WHERE submitted_by = $1 AND in_pool
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch songs", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	songs := []models.Song{}
+	for rows.Next() {
+		var s models.Song
+		if err := rows.Scan(&s.ID, &s.URL, &s.Platform, &s.ContextCrumb, &s.CreatedAt); err != nil {
+			continue
+		}
+		songs = append(songs, s)
+	}
+
+	attachReactions(songPointers(songs))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(songs)
+}
+
+// GetProfileLikes lists the songs a user liked, if they made that public
+func GetProfileLikes(w http.ResponseWriter, r *http.Request) {
+	userID, _, showLikes, ok := lookupProfileUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !showLikes {
+		http.Error(w, "This user's likes are private", http.StatusForbidden)
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r, 20)
+	if !ok {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at, d.liked_at
+		FROM discoveries d
+		JOIN songs s ON d.song_id = s.id
+		WHERE d.user_id = $1 AND d.liked = true
+		ORDER BY d.liked_at DESC NULLS LAST
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch likes", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	songs := []models.LikedSong{}
+	for rows.Next() {
+		var s models.LikedSong
+		if err := rows.Scan(&s.ID, &s.URL, &s.Platform, &s.ContextCrumb, &s.CreatedAt, &s.LikedAt); err != nil {
+			continue
+		}
+		songs = append(songs, s)
+	}
+
+	liked := make([]*models.Song, len(songs))
+	for i := range songs {
+		liked[i] = &songs[i].Song
+	}
+	attachReactions(liked)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(songs)
+}
+
+// validateProfileText trims a display name or bio; "" means clear it
+func validateProfileText(field string, value *string, maxLength int) string {
+	*value = strings.TrimSpace(*value)
+	if utf8.RuneCountInString(*value) > maxLength {
+		return field + " must be at most " + strconv.Itoa(maxLength) + " characters"
+	}
+	for _, c := range *value {
+		if unicode.IsControl(c) && c != '\n' {
+			return field + " contains invalid characters"
+		}
+	}
+	return ""
+}
+
+// validateAvatarURL only allows https, so profiles can't mix insecure content into the page
+func validateAvatarURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if len(raw) > maxAvatarURLLength {
+		return "Avatar URL is too long"
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return "Avatar URL must be an https:// URL"
+	}
+	return ""
+}
+
+// validateProfileUpdate returns a user-facing error message, or "" if the update is acceptable
+func validateProfileUpdate(req *models.UpdateProfileRequest) string {
+	if req.DisplayName != nil {
+		if msg := validateProfileText("Display name", req.DisplayName, maxDisplayNameLength); msg != "" {
+			return msg
+		}
+		// A display name is shown on one line
+		if strings.Contains(*req.DisplayName, "\n") {
+			return "Display name contains invalid characters"
+		}
+	}
+	if req.Bio != nil {
+		if msg := validateProfileText("Bio", req.Bio, maxBioLength); msg != "" {
+			return msg
+		}
+	}
+	if req.AvatarURL != nil {
+		*req.AvatarURL = strings.TrimSpace(*req.AvatarURL)
+		if msg := validateAvatarURL(*req.AvatarURL); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// UpdateProfile edits the authenticated user's profile and privacy settings
+func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateProfileUpdate(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// $n IS NOT NULL picks which columns the request touches; a cleared text field is sent as ''
+	_, err := database.DB.Exec(`
+		UPDATE users SET
+			display_name = CASE WHEN $2::text IS NOT NULL THEN NULLIF($2, '') ELSE display_name END,
+			bio = CASE WHEN $3::text IS NOT NULL THEN NULLIF($3, '') ELSE bio END,
+			avatar_url = CASE WHEN $4::text IS NOT NULL THEN NULLIF($4, '') ELSE avatar_url END,
+			show_submissions = COALESCE($5, show_submissions),
+			show_likes = COALESCE($6, show_likes)
+		WHERE id = $1
+	`, userID, req.DisplayName, req.Bio, req.AvatarURL, req.ShowSubmissions, req.ShowLikes)
+	if err != nil {
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := loadProfile(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/lib/pq"
+)
+
+// songParticipant reports whether a song exists and whether the user may react
+// to it and join its thread, which takes having discovered or submitted it
+func songParticipant(userID, songID int64) (exists, allowed bool, err error) {
+	err = database.DB.QueryRow(`
+		SELECT
+			EXISTS(SELECT 1 FROM songs WHERE id = $1),
+			EXISTS(SELECT 1 FROM discoveries WHERE song_id = $1 AND user_id = $2)
+				OR EXISTS(SELECT 1 FROM songs WHERE id = $1 AND submitted_by = $2)
+	`, songID, userID).Scan(&exists, &allowed)
+	return exists, allowed, err
+}
+
+// requireSongParticipant parses the song ID from the path and checks the user
+// can take part in its reactions and comments, answering the request if not
+func requireSongParticipant(w http.ResponseWriter, userID int64, rawID, failure string) (int64, bool) {
+	songID, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid song ID", http.StatusBadRequest)
+		return 0, false
+	}
+
+	exists, allowed, err := songParticipant(userID, songID)
+	if err != nil {
+		http.Error(w, failure, http.StatusInternalServerError)
+		return 0, false
+	}
+	if !exists {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return 0, false
+	}
+	if !allowed {
+		http.Error(w, "Discover this song first", http.StatusForbidden)
+		return 0, false
+	}
+	return songID, true
+}
+
+// attachReactions fills in the reaction counts of songs. It's best effort: a
+// failure is logged and the songs are returned without counts.
+func attachReactions(songs []*models.Song) {
+	if len(songs) == 0 {
+		return
+	}
+
+	ids := make([]int64, len(songs))
+	for i, s := range songs {
+		ids[i] = s.ID
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT song_id, emoji, COUNT(*)
+		FROM song_reactions
+		WHERE song_id = ANY($1)
+		GROUP BY song_id, emoji
+	`, pq.Array(ids))
+	if err != nil {
+		log.Println("Failed to load reactions:", err)
+		return
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]map[string]int)
+	for rows.Next() {
+		var songID int64
+		var emoji string
+		var n int
+		if err := rows.Scan(&songID, &emoji, &n); err != nil {
+			continue
+		}
+		if counts[songID] == nil {
+			counts[songID] = make(map[string]int)
+		}
+		counts[songID][emoji] = n
+	}
+
+	for _, s := range songs {
+		s.Reactions = counts[s.ID]
+	}
+}
+
+// songPointers points into songs so attachReactions can fill them in place
+func songPointers(songs []models.Song) []*models.Song {
+	ptrs := make([]*models.Song, len(songs))
+	for i := range songs {
+		ptrs[i] = &songs[i]
+	}
+	return ptrs
+}
+
+// loadReactionSummary returns a song's reaction counts and the user's own reactions
+func loadReactionSummary(userID, songID int64) (models.ReactionSummary, error) {
+	summary := models.ReactionSummary{SongID: songID, Counts: map[string]int{}, Mine: []string{}}
+
+	rows, err := database.DB.Query(`
+		SELECT emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM song_reactions
+		WHERE song_id = $1
+		GROUP BY emoji
+	`, songID, userID)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var emoji string
+		var n int
+		var mine bool
+		if err := rows.Scan(&emoji, &n, &mine); err != nil {
+			return summary, err
+		}
+		summary.Counts[emoji] = n
+		if mine {
+			summary.Mine = append(summary.Mine, emoji)
+		}
+	}
+	return summary, rows.Err()
+}
+
+func writeReactionSummary(w http.ResponseWriter, userID, songID int64) {
+	summary, err := loadReactionSummary(userID, songID)
+	if err != nil {
+		http.Error(w, "Failed to fetch reactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// GetReactions returns a song's reaction counts and which of them are the caller's
+func GetReactions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsRead) {
+		return
+	}
+
+	songID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid song ID", http.StatusBadRequest)
+		return
+	}
+
+	exists, _, err := songParticipant(userID, songID)
+	if err != nil {
+		http.Error(w, "Failed to fetch reactions", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return
+	}
+
+	writeReactionSummary(w, userID, songID)
+}
+
+// AddReaction reacts to a song the caller has discovered. Reacting twice with
+// the same emoji is a no-op.
+func AddReaction(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	var req models.ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !models.ValidReaction(req.Emoji) {
+		http.Error(w, "Unsupported reaction", http.StatusBadRequest)
+		return
+	}
+
+	songID, ok := requireSongParticipant(w, userID, r.PathValue("id"), "Failed to add reaction")
+	if !ok {
+		return
+	}
+
+	_, err := database.DB.Exec(`
+		INSERT INTO song_reactions (song_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, songID, userID, req.Emoji)
+	if err != nil {
+		http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
+		return
+	}
+
+	writeReactionSummary(w, userID, songID)
+}
+
+// RemoveReaction takes back one of the caller's reactions
+func RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	emoji := r.PathValue("emoji")
+	if !models.ValidReaction(emoji) {
+		http.Error(w, "Unsupported reaction", http.StatusBadRequest)
+		return
+	}
+
+	songID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid song ID", http.StatusBadRequest)
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		DELETE FROM song_reactions
+		WHERE song_id = $1 AND user_id = $2 AND emoji = $3
+	`, songID, userID, emoji)
+	if err != nil {
+		http.Error(w, "Failed to remove reaction", http.StatusInternalServerError)
+		return
+	}
+
+	writeReactionSummary(w, userID, songID)
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/halva/songswap/internal/party"
+)
+
+const (
+	roomPingInterval = 30 * time.Second
+	roomPongWait     = 60 * time.Second
+	roomWriteWait    = 10 * time.Second
+	// roomMaxMessage is plenty for any command a member can send
+	roomMaxMessage = 4096
+)
+
+// partyHub holds the listening party rooms. It is in-process, so everyone in a
+// room has to be connected to the same API instance.
+var partyHub = party.NewHub(chainSongSource{})
+
+// chainSongSource looks chain songs up in the database for the party hub
+type chainSongSource struct{}
+
+func (chainSongSource) ChainSong(chainID, songID int64) (models.Song, bool, error) {
+	var s models.Song
+	err := database.DB.QueryRow(`
+		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at
+		FROM chain_songs cs
+		JOIN songs s ON cs.song_id = s.id
+		WHERE cs.chain_id = $1 AND cs.song_id = $2
+	`, chainID, songID).Scan(&s.ID, &s.URL, &s.Platform, &s.ContextCrumb, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return s, false, nil
+	}
+	return s, err == nil, err
+}
+
+func (chainSongSource) NextChainSong(chainID, afterSongID int64) (models.Song, bool, error) {
+	// Songs play in the order they were added; after the last one it starts over
+	var s models.Song
+	err := database.DB.QueryRow(`
+		WITH now_playing AS (
+			SELECT added_at, song_id FROM chain_songs WHERE chain_id = $1 AND song_id = $2
+		)
+		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at
+		FROM chain_songs cs
+		JOIN songs s ON cs.song_id = s.id
+		WHERE cs.chain_id = $1 AND s.platform IN ('youtube', 'spotify')
+		ORDER BY
+			EXISTS (SELECT 1 FROM now_playing np WHERE (cs.added_at, cs.song_id) <= (np.added_at, np.song_id)),
+			cs.added_at, cs.song_id
+		LIMIT 1
+	`, chainID, afterSongID).Scan(&s.ID, &s.URL, &s.Platform, &s.ContextCrumb, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return s, false, nil
+	}
+	return s, err == nil, err
+}
+
+var (
+	roomOrigins     map[string]bool
+	roomOriginsOnce sync.Once
+)
+
+var roomUpgrader = websocket.Upgrader{
+	// Browsers always send Origin; only our own frontend may open a room socket
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		roomOriginsOnce.Do(func() { roomOrigins = middleware.AllowedOrigins() })
+		return roomOrigins[origin]
+	},
+}
+
+// CreateRoom opens a listening party on a chain, hosted by the authenticated user
+func CreateRoom(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeChainsRead) {
+		return
+	}
+
+	chainID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chain ID", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM chains WHERE id = $1)", chainID).Scan(&exists)
+	if err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Chain not found", http.StatusNotFound)
+		return
+	}
+
+	var username string
+	if err := database.DB.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username); err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+
+	room, err := partyHub.Create(chainID, userID, username)
+	if errors.Is(err, party.ErrAlreadyHosting) {
+		http.Error(w, "You're already hosting a room", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(room.Info())
+}
+
+// ListRooms lists the open listening parties on a chain
+func ListRooms(w http.ResponseWriter, r *http.Request) {
+	chainID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chain ID", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(partyHub.ChainRooms(chainID))
+}
+
+// GetRoom returns a listening party's summary
+func GetRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := partyHub.Room(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room.Info())
+}
+
+// RoomTicket issues a short-lived, single-use ticket for joining a room's
+// WebSocket, since browsers can't send an Authorization header there
+func RoomTicket(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeChainsRead) {
+		return
+	}
+
+	roomID := r.PathValue("id")
+	if _, ok := partyHub.Room(roomID); !ok {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	var username string
+	if err := database.DB.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username); err != nil {
+		http.Error(w, "Failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	ticket, err := partyHub.IssueTicket(roomID, userID, username)
+	if errors.Is(err, party.ErrRoomNotFound) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to issue ticket", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ticket)
+}
+
+// RoomSocket joins a room with a ticket from RoomTicket and relays commands
+// and room messages over a WebSocket until either side leaves
+func RoomSocket(w http.ResponseWriter, r *http.Request) {
+	// Checked before the ticket is spent; the upgrade checks again
+	if !roomUpgrader.CheckOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	room, member, err := partyHub.Join(r.PathValue("id"), r.URL.Query().Get("ticket"))
+	switch {
+	case errors.Is(err, party.ErrInvalidTicket):
+		http.Error(w, "Invalid or expired ticket", http.StatusUnauthorized)
+		return
+	case errors.Is(err, party.ErrRoomNotFound):
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	case errors.Is(err, party.ErrRoomFull):
+		http.Error(w, "Room is full", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, "Failed to join room", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := roomUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request
+		room.Leave(member)
+		return
+	}
+
+	go writeRoomMessages(conn, member)
+	readRoomCommands(conn, room, member)
+}
+
+// readRoomCommands passes commands to the room until the connection drops
+func readRoomCommands(conn *websocket.Conn, room *party.Room, member *party.Member) {
+	defer room.Leave(member)
+
+	conn.SetReadLimit(roomMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(roomPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(roomPongWait))
+	})
+
+	for {
+		var cmd party.Command
+		if err := conn.ReadJSON(&cmd); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Room socket closed:", err)
+			}
+			return
+		}
+		room.Handle(member, cmd)
+	}
+}
+
+// writeRoomMessages sends room messages and keepalive pings, and closes the
+// connection once the member's outbox is closed
+func writeRoomMessages(conn *websocket.Conn, member *party.Member) {
+	ping := time.NewTicker(roomPingInterval)
+	defer func() {
+		ping.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case payload, ok := <-member.Outbox():
+			conn.SetWriteDeadline(time.Now().Add(roomWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(roomWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
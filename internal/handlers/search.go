@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/halva/songswap/internal/search"
+)
+
+var SearchStore search.Store
+
+func SetSearchStore(store search.Store) {
+	SearchStore = store
+}
+
+// Search looks up songs, chains and users matching ?q=, grouped by kind.
+// ?kind= narrows to one or more kinds, ?limit= and ?offset= page each group.
+// Songs only come with their URL for a signed-in caller who submitted or
+// discovered them.
+func Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	text := strings.TrimSpace(query.Get("q"))
+	if text == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	if len(text) > 100 {
+		http.Error(w, "Search query must be under 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	q := search.Query{Text: text, Limit: 20}
+	if userID, ok := r.Context().Value(middleware.UserIDKey).(int64); ok && middleware.HasScope(r.Context(), middleware.ScopeSongsRead) {
+		q.UserID = userID
+	}
+
+	if kinds := query.Get("kind"); kinds != "" {
+		q.Kinds = make(map[string]bool)
+		for _, kind := range strings.Split(kinds, ",") {
+			switch kind {
+			case models.SearchKindSong, models.SearchKindChain, models.SearchKindUser:
+				q.Kinds[kind] = true
+			default:
+				http.Error(w, "Kind must be song, chain or user", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 50 {
+			http.Error(w, "Limit must be between 1 and 50", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			http.Error(w, "Offset must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		q.Offset = n
+	}
+
+	results, err := SearchStore.Search(r.Context(), q)
+	if err != nil {
+		log.Println("Search error:", err)
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
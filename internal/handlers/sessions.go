@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// tokenPair is what a login or refresh hands back to the client
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// authResponse fills an AuthResponse from a token pair
+func authResponse(tokens tokenPair, user models.User) models.AuthResponse {
+	return models.AuthResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         user,
+	}
+}
+
+// loginPassword is the login method recorded for password sessions;
+// OAuth sessions record the provider name
+const loginPassword = "password"
+
+// startSession opens a new session for the user and issues its first token pair
+func startSession(r *http.Request, userID int64, method string) (tokenPair, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return tokenPair{}, err
+	}
+	defer tx.Rollback()
+
+	var sessionID int64
+	err = tx.QueryRow(`
+		INSERT INTO sessions (user_id, expires_at, user_agent, ip, login_method)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, userID, time.Now().Add(refreshTokenTTL), truncate(r.UserAgent(), 512), middleware.RealIP(r), method).Scan(&sessionID)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	refreshToken, err := insertRefreshToken(tx, sessionID)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return tokenPair{}, err
+	}
+
+	accessToken, err := createToken(userID, sessionID)
+	if err != nil {
+		return tokenPair{}, err
+	}
+	return tokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// insertRefreshToken generates a new refresh token for a session and stores its hash
+func insertRefreshToken(tx *sql.Tx, sessionID int64) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO refresh_tokens (session_id, token_hash)
+		VALUES ($1, $2)
+	`, sessionID, hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// truncate cuts s to at most n bytes without splitting a character. Invalid
+// UTF-8, which headers can carry, is dropped since Postgres would reject it.
+func truncate(s string, n int) string {
+	s = strings.ToValidUTF8(s, "")
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// randomToken returns 32 random bytes, base64url encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how refresh tokens are stored; they are high-entropy so a plain SHA-256 is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// readRefreshToken takes the refresh token from the body, or from the cookie
+// for clients that logged in with use_cookie. The body is optional for them.
+func readRefreshToken(r *http.Request) (token string, fromCookie bool, err error) {
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return "", false, err
+	}
+	if req.RefreshToken != "" {
+		return req.RefreshToken, false, nil
+	}
+	if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+		return cookie.Value, true, nil
+	}
+	return "", false, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken, fromCookie, err := readRefreshToken(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if refreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var tokenID, sessionID int64
+	var usedAt, revokedAt *time.Time
+	var expiresAt time.Time
+	var user models.User
+	err = tx.QueryRow(`
+		SELECT rt.id, rt.session_id, rt.used_at, s.revoked_at, s.expires_at, u.id, u.username, u.created_at
+		FROM refresh_tokens rt
+		JOIN sessions s ON rt.session_id = s.id
+		JOIN users u ON s.user_id = u.id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s
+	`, hashToken(refreshToken)).Scan(&tokenID, &sessionID, &usedAt, &revokedAt, &expiresAt,
+		&user.ID, &user.Username, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	if revokedAt != nil || time.Now().After(expiresAt) {
+		http.Error(w, "Session expired", http.StatusUnauthorized)
+		return
+	}
+
+	// A used token showing up again means it leaked: kill the whole family
+	if usedAt != nil {
+		tx.Exec(`UPDATE sessions SET revoked_at = NOW() WHERE id = $1`, sessionID)
+		tx.Commit()
+		http.Error(w, "Refresh token reuse detected", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1`, tokenID); err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE sessions SET last_used_at = NOW() WHERE id = $1`, sessionID); err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	newRefreshToken, err := insertRefreshToken(tx, sessionID)
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := createToken(user.ID, sessionID)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	tokens := tokenPair{AccessToken: accessToken, RefreshToken: newRefreshToken}
+
+	w.Header().Set("Content-Type", "application/json")
+	if fromCookie {
+		setAuthCookies(w, r, tokens)
+		json.NewEncoder(w).Encode(models.AuthResponse{ExpiresIn: int(accessTokenTTL.Seconds()), User: user})
+		return
+	}
+	json.NewEncoder(w).Encode(authResponse(tokens, user))
+}
+
+// Logout revokes the session the given refresh token belongs to
+func Logout(w http.ResponseWriter, r *http.Request) {
+	refreshToken, _, err := readRefreshToken(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if refreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE revoked_at IS NULL
+		AND id = (SELECT session_id FROM refresh_tokens WHERE token_hash = $1)
+	`, hashToken(refreshToken))
+
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	clearAuthCookies(w, r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"logged_out": true}`))
+}
+
+// LogoutAll revokes every session of the authenticated user
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	_, err := database.DB.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`, userID)
+
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"logged_out": true}`))
+}
+
+// SessionActive reports whether a session exists and has been neither revoked nor expired.
+// It is installed as the middleware's session checker.
+func SessionActive(sessionID int64) (bool, error) {
+	var active bool
+	err := database.DB.QueryRow(`
+		SELECT revoked_at IS NULL AND expires_at > NOW()
+		FROM sessions
+		WHERE id = $1
+	`, sessionID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return active, err
+}
+
+// ListSessions returns the authenticated user's active sessions
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+	currentID, _ := r.Context().Value(middleware.SessionIDKey).(int64)
+
+	rows, err := database.DB.Query(`
+		SELECT id, login_method, user_agent, ip, created_at, last_used_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC
+	`, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(&s.ID, &s.LoginMethod, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt); err != nil {
+			continue
+		}
+		s.Current = s.ID == currentID
+		sessions = append(sessions, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession revokes one of the authenticated user's sessions
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	sessionID := r.PathValue("id")
+	if sessionID == "" {
+		http.Error(w, "Session ID required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, sessionID, userID)
+
+	if err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"revoked": true}`))
+}
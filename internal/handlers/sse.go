@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseHeartbeat keeps idle event streams open through proxies that drop quiet connections
+const sseHeartbeat = 15 * time.Second
+
+// startSSE sends the headers for a Server-Sent Events stream, answering 500 if
+// the connection can't be streamed
+func startSSE(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return flusher, true
+}
+
+// writeSSE writes one event with a JSON payload; id is left out when empty
+func writeSSE(w http.ResponseWriter, event, id string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// writeSSEHeartbeat writes a comment line, which clients ignore
+func writeSSEHeartbeat(w http.ResponseWriter) error {
+	_, err := fmt.Fprint(w, ": ping\n\n")
+	return err
+}
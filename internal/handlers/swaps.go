@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/events"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+const (
+	swapTTL           = 7 * 24 * time.Hour
+	maxSwapNoteLength = 200
+)
+
+// swapColumns selects a swap with both usernames and both songs, for scanSwap
+const swapColumns = `
+	SELECT sw.id, su.username, ru.username, sw.note,
+		s.id, s.url, s.platform, s.context_crumb, s.created_at,
+		rs.id, rs.url, rs.platform, rs.context_crumb, rs.created_at,
+		sw.reply_note, sw.status, sw.created_at, sw.expires_at, sw.completed_at
+	FROM swaps sw
+	JOIN users su ON sw.sender_id = su.id
+	JOIN users ru ON sw.recipient_id = ru.id
+	JOIN songs s ON sw.song_id = s.id
+	LEFT JOIN songs rs ON sw.reply_song_id = rs.id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSwap(row rowScanner) (models.Swap, error) {
+	var sw models.Swap
+	var song models.Song
+	var replyID *int64
+	var replyURL, replyPlatform *string
+	var replyCrumb *string
+	var replyCreatedAt *time.Time
+
+	err := row.Scan(&sw.ID, &sw.Sender, &sw.Recipient, &sw.Note,
+		&song.ID, &song.URL, &song.Platform, &song.ContextCrumb, &song.CreatedAt,
+		&replyID, &replyURL, &replyPlatform, &replyCrumb, &replyCreatedAt,
+		&sw.ReplyNote, &sw.Status, &sw.CreatedAt, &sw.ExpiresAt, &sw.CompletedAt)
+	if err != nil {
+		return sw, err
+	}
+
+	sw.Song = &song
+	if replyID != nil {
+		sw.ReplySong = &models.Song{
+			ID:           *replyID,
+			URL:          *replyURL,
+			Platform:     *replyPlatform,
+			ContextCrumb: replyCrumb,
+			CreatedAt:    *replyCreatedAt,
+		}
+	}
+	return sw, nil
+}
+
+// expireSwaps marks overdue pending swaps as expired. Expiry is applied lazily,
+// before anything reads or changes swap state.
+func expireSwaps() error {
+	_, err := database.DB.Exec(`
+		UPDATE swaps SET status = 'expired'
+		WHERE status = 'pending' AND expires_at <= NOW()
+	`)
+	return err
+}
+
+// validateSwapSong checks the cheap parts of a swap song first, so a bad note
+// doesn't cost a request to the song's URL
+func validateSwapSong(req *models.SwapSongRequest) string {
+	if req.Note != nil {
+		*req.Note = strings.TrimSpace(*req.Note)
+		if utf8.RuneCountInString(*req.Note) > maxSwapNoteLength {
+			return "Note must be at most 200 characters"
+		}
+		if *req.Note == "" {
+			req.Note = nil
+		}
+	}
+
+	if req.SongID != nil && req.URL != "" {
+		return "Send either a song_id or a url, not both"
+	}
+	if req.SongID == nil && req.URL == "" {
+		return "A song_id or url is required"
+	}
+	if req.SongID != nil {
+		return ""
+	}
+
+	return validateSongSubmission(req.URL, req.ContextCrumb)
+}
+
+// resolveSwapSong returns the ID of the song being sent, saving it outside the
+// pool if it's new. An existing song has to be one the user submitted or discovered,
+// so swaps can't be used to read songs by ID; any other is sql.ErrNoRows.
+func resolveSwapSong(q rowQueryer, userID int64, req models.SwapSongRequest) (int64, error) {
+	if req.SongID != nil {
+		var songID int64
+		err := q.QueryRow(`
+			SELECT id FROM songs s
+			WHERE id = $1 AND (
+				submitted_by = $2
+				OR EXISTS(SELECT 1 FROM discoveries WHERE song_id = s.id AND user_id = $2)
+			)
+		`, *req.SongID, userID).Scan(&songID)
+		return songID, err
+	}
+
+	song, err := insertSong(q, userID, req.URL, req.ContextCrumb, false)
+	return song.ID, err
+}
+
+// CreateSwap sends a song straight to another user. They only get to hear it
+// once they send one back.
+func CreateSwap(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	var req models.CreateSwapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Recipient = strings.TrimSpace(req.Recipient)
+	if req.Recipient == "" {
+		http.Error(w, "Recipient is required", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateSwapSong(&req.SwapSongRequest); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var recipientID int64
+	err := database.DB.QueryRow(
+		"SELECT id FROM users WHERE username = $1", req.Recipient,
+	).Scan(&recipientID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create swap", http.StatusInternalServerError)
+		return
+	}
+
+	if recipientID == userID {
+		http.Error(w, "You can't swap with yourself", http.StatusBadRequest)
+		return
+	}
+
+	// A swap that just ran out shouldn't block a new one
+	if err := expireSwaps(); err != nil {
+		http.Error(w, "Failed to create swap", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create swap", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var pending bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM swaps
+			WHERE sender_id = $1 AND recipient_id = $2 AND status = 'pending'
+		)
+	`, userID, recipientID).Scan(&pending)
+	if err != nil {
+		http.Error(w, "Failed to create swap", http.StatusInternalServerError)
+		return
+	}
+	if pending {
+		http.Error(w, "You already have a pending swap with this user", http.StatusConflict)
+		return
+	}
+
+	// A new song is only kept if the swap is
+	songID, err := resolveSwapSong(tx, userID, req.SwapSongRequest)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save song", http.StatusInternalServerError)
+		return
+	}
+
+	var swapID int64
+	err = tx.QueryRow(`
+		INSERT INTO swaps (sender_id, recipient_id, song_id, note, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, userID, recipientID, songID, req.Note, time.Now().Add(swapTTL)).Scan(&swapID)
+	// Another request can still create one between the check and here
+	if database.IsUniqueViolation(err) {
+		http.Error(w, "You already have a pending swap with this user", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create swap", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create swap", http.StatusInternalServerError)
+		return
+	}
+
+	swap, err := scanSwap(database.DB.QueryRow(swapColumns+"WHERE sw.id = $1", swapID))
+	if err != nil {
+		http.Error(w, "Failed to fetch swap", http.StatusInternalServerError)
+		return
+	}
+
+	// The recipient sees it the way their inbox does, without the song
+	received := swap
+	received.Song = nil
+	eventBus.Publish(events.UserTopic(recipientID), events.SwapReceived, received)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(swap)
+}
+
+// listSwaps pages through the swaps matching where, newest first
+func listSwaps(w http.ResponseWriter, r *http.Request, where string, userID int64) ([]models.Swap, bool) {
+	limit, offset, ok := parsePage(w, r, 20)
+	if !ok {
+		return nil, false
+	}
+
+	if err := expireSwaps(); err != nil {
+		http.Error(w, "Failed to fetch swaps", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	rows, err := database.DB.Query(swapColumns+where+`
+		ORDER BY sw.created_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to fetch swaps", http.StatusInternalServerError)
+		return nil, false
+	}
+	defer rows.Close()
+
+	swaps := []models.Swap{}
+	for rows.Next() {
+		swap, err := scanSwap(rows)
+		if err != nil {
+			continue
+		}
+		swaps = append(swaps, swap)
+	}
+	return swaps, true
+}
+
+// Inbox lists swaps sent to the authenticated user. A song stays hidden until
+// the user has sent one back.
+func Inbox(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsRead) {
+		return
+	}
+
+	swaps, ok := listSwaps(w, r, "WHERE sw.recipient_id = $1", userID)
+	if !ok {
+		return
+	}
+
+	for i := range swaps {
+		if swaps[i].Status != models.SwapCompleted {
+			swaps[i].Song = nil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(swaps)
+}
+
+// SentSwaps lists swaps the authenticated user has sent
+func SentSwaps(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsRead) {
+		return
+	}
+
+	swaps, ok := listSwaps(w, r, "WHERE sw.sender_id = $1", userID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(swaps)
+}
+
+// ReplySwap sends a song back to the sender of a pending swap, which unlocks
+// the sender's song. Both songs are delivered as discoveries.
+func ReplySwap(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeSongsWrite) {
+		return
+	}
+
+	swapID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid swap ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.SwapSongRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateSwapSong(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if err := expireSwaps(); err != nil {
+		http.Error(w, "Failed to reply to swap", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to reply to swap", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var senderID, recipientID, songID int64
+	var status string
+	err = tx.QueryRow(`
+		SELECT sender_id, recipient_id, song_id, status
+		FROM swaps WHERE id = $1
+		FOR UPDATE
+	`, swapID).Scan(&senderID, &recipientID, &songID, &status)
+	// Other people's swaps look the same as missing ones
+	if err == sql.ErrNoRows || (err == nil && recipientID != userID) {
+		http.Error(w, "Swap not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to reply to swap", http.StatusInternalServerError)
+		return
+	}
+
+	if status != models.SwapPending {
+		http.Error(w, "This swap is already "+status, http.StatusConflict)
+		return
+	}
+
+	// In the transaction, so a new song is only kept if the reply goes through
+	replySongID, err := resolveSwapSong(tx, userID, req)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Song not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to save song", http.StatusInternalServerError)
+		return
+	}
+
+	if replySongID == songID {
+		http.Error(w, "Send back a different song", http.StatusBadRequest)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE swaps
+		SET reply_song_id = $2, reply_note = $3, status = 'completed', completed_at = NOW()
+		WHERE id = $1
+	`, swapID, replySongID, req.Note)
+	if err != nil {
+		http.Error(w, "Failed to reply to swap", http.StatusInternalServerError)
+		return
+	}
+
+	// A song either side already discovered keeps its original history entry
+	_, err = tx.Exec(`
+		INSERT INTO discoveries (user_id, song_id, swap_id)
+		VALUES ($1, $2, $5), ($3, $4, $5)
+		ON CONFLICT (user_id, song_id)
+		DO UPDATE SET swap_id = COALESCE(discoveries.swap_id, EXCLUDED.swap_id)
+	`, recipientID, songID, senderID, replySongID, swapID)
+	if err != nil {
+		http.Error(w, "Failed to deliver swap", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to reply to swap", http.StatusInternalServerError)
+		return
+	}
+
+	swap, err := scanSwap(database.DB.QueryRow(swapColumns+"WHERE sw.id = $1", swapID))
+	if err != nil {
+		http.Error(w, "Failed to fetch swap", http.StatusInternalServerError)
+		return
+	}
+
+	eventBus.Publish(events.UserTopic(senderID), events.SwapCompleted, swap)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(swap)
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/lib/pq"
+)
+
+const (
+	maxAPITokens          = 50
+	maxAPITokenExpiryDays = 365
+)
+
+// requireScope answers 403 unless the request may act with the scope.
+// Session logins always pass; personal access tokens need it granted.
+func requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	if middleware.HasScope(r.Context(), scope) {
+		return true
+	}
+	http.Error(w, "Token is missing the "+scope+" scope", http.StatusForbidden)
+	return false
+}
+
+// LookupAPIToken resolves a personal access token for the auth middleware and
+// records that it was used
+func LookupAPIToken(token string) (int64, []string, bool, error) {
+	var userID int64
+	var scopes []string
+	err := database.DB.QueryRow(`
+		UPDATE api_tokens SET last_used_at = NOW()
+		WHERE token_hash = $1
+		AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING user_id, scopes
+	`, hashToken(token)).Scan(&userID, pq.Array(&scopes))
+	if err == sql.ErrNoRows {
+		return 0, nil, false, nil
+	} else if err != nil {
+		return 0, nil, false, err
+	}
+	return userID, scopes, true, nil
+}
+
+// validateTokenRequest returns a user-facing error message, or "" if the request is acceptable
+func validateTokenRequest(req *models.CreateAPITokenRequest) string {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return "Name is required"
+	}
+	if len(req.Name) > 100 {
+		return "Name must be under 100 characters"
+	}
+
+	if len(req.Scopes) == 0 {
+		return "At least one scope is required"
+	}
+	for _, scope := range req.Scopes {
+		if !middleware.ValidScope(scope) {
+			return "Unknown scope: " + scope
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPITokenExpiryDays {
+		return "Expiry must be between 0 and 365 days"
+	}
+	return ""
+}
+
+// CreateAPIToken issues a named, scoped personal access token. The token is
+// only returned here; we keep just its hash.
+func CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateTokenRequest(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var active int
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*) FROM api_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, userID).Scan(&active)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	if active >= maxAPITokens {
+		http.Error(w, "Too many active tokens, revoke one first", http.StatusConflict)
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	secret = middleware.APITokenPrefix + secret
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	resp := models.CreateAPITokenResponse{Token: secret}
+	err = database.DB.QueryRow(`
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, name, scopes, created_at, last_used_at, expires_at
+	`, userID, req.Name, hashToken(secret), pq.Array(req.Scopes), expiresAt).Scan(
+		&resp.ID, &resp.Name, pq.Array(&resp.Scopes), &resp.CreatedAt, &resp.LastUsedAt, &resp.ExpiresAt,
+	)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListAPITokens returns the user's active personal access tokens
+func ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT id, name, scopes, created_at, last_used_at, expires_at
+		FROM api_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tokens", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		if err := rows.Scan(&t.ID, &t.Name, pq.Array(&t.Scopes), &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt); err != nil {
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeAPIToken revokes one of the user's personal access tokens
+func RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	tokenID := r.PathValue("id")
+	if tokenID == "" {
+		http.Error(w, "Token ID required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := database.DB.Exec(`
+		UPDATE api_tokens SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, tokenID, userID)
+	if err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"revoked": true}`))
+}
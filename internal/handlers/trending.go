@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+)
+
+const (
+	// trendingHalfLife is how long it takes an addition or like to lose half its weight
+	trendingHalfLife = 48 * time.Hour
+	// trendingWindow bounds how far back activity is considered at all
+	trendingWindow = 14 * 24 * time.Hour
+)
+
+// chainSortOrders maps ?sort= values on ListChains to ORDER BY clauses
+var chainSortOrders = map[string]string{
+	"new":        "c.created_at DESC",
+	"trending":   "COALESCE(sc.trending_score, 0) DESC, c.created_at DESC",
+	"most_songs": "song_count DESC, c.created_at DESC",
+	"most_liked": "COALESCE(sc.like_count, 0) DESC, c.created_at DESC",
+}
+
+// chainOrderBy returns the ORDER BY clause for a sort key, defaulting to newest first
+func chainOrderBy(sort string) (string, bool) {
+	if sort == "" {
+		sort = "new"
+	}
+	order, ok := chainSortOrders[sort]
+	return order, ok
+}
+
+// RefreshChainScores recomputes trending scores and like counts for every chain.
+// Each chain_songs addition and each like contributes 0.5^(age / half-life).
+// Songs a fork inherited keep their original added_at, from before the fork
+// was created, and don't count towards its score; nor do likes a song got
+// before it was added to the chain.
+func RefreshChainScores() error {
+	halfLifeHours := trendingHalfLife.Hours()
+	windowHours := trendingWindow.Hours()
+
+	_, err := database.DB.Exec(`
+		INSERT INTO chain_scores (chain_id, trending_score, like_count, computed_at)
+		SELECT c.id,
+			COALESCE((
+				SELECT SUM(POWER(0.5, EXTRACT(EPOCH FROM NOW() - cs.added_at) / 3600 / $1))
+				FROM chain_songs cs
+				WHERE cs.chain_id = c.id
+				AND cs.added_at >= c.created_at
+				AND cs.added_at > NOW() - make_interval(hours => $2)
+			), 0) + COALESCE((
+				SELECT SUM(POWER(0.5, EXTRACT(EPOCH FROM NOW() - d.liked_at) / 3600 / $1))
+				FROM chain_songs cs
+				JOIN discoveries d ON d.song_id = cs.song_id
+				WHERE cs.chain_id = c.id AND d.liked = true
+				AND cs.added_at >= c.created_at
+				AND d.liked_at >= cs.added_at
+				AND d.liked_at > NOW() - make_interval(hours => $2)
+			), 0),
+			(
+				SELECT COUNT(*)
+				FROM chain_songs cs
+				JOIN discoveries d ON d.song_id = cs.song_id
+				WHERE cs.chain_id = c.id AND d.liked = true
+			),
+			NOW()
+		FROM chains c
+		ON CONFLICT (chain_id) DO UPDATE SET
+			trending_score = EXCLUDED.trending_score,
+			like_count = EXCLUDED.like_count,
+			computed_at = EXCLUDED.computed_at
+	`, halfLifeHours, int(windowHours))
+	return err
+}
+
+// StartTrendingJob refreshes chain scores now and then on every interval
+func StartTrendingJob(interval time.Duration) {
+	go func() {
+		for {
+			if err := RefreshChainScores(); err != nil {
+				log.Println("RefreshChainScores error:", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
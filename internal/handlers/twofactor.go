@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+	"github.com/halva/songswap/internal/totp"
+)
+
+const (
+	totpIssuer        = "songswap"
+	mfaChallengeTTL   = 5 * time.Minute
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// startMFAChallenge issues a challenge token if the user has 2FA enabled.
+// Callers hand the token back instead of starting a session.
+func startMFAChallenge(userID int64, method string) (token string, required bool, err error) {
+	err = database.DB.QueryRow(
+		`SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1`, userID,
+	).Scan(&required)
+	if err != nil || !required {
+		return "", false, err
+	}
+
+	token, err = randomToken()
+	if err != nil {
+		return "", true, err
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO mfa_challenges (token_hash, user_id, login_method, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, hashToken(token), userID, method, time.Now().Add(mfaChallengeTTL))
+	if err != nil {
+		return "", true, err
+	}
+
+	database.DB.Exec(`DELETE FROM mfa_challenges WHERE expires_at < NOW()`)
+	return token, true, nil
+}
+
+func writeMFAChallenge(w http.ResponseWriter, token string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.MFAChallenge{MFARequired: true, MFAToken: token})
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// generateRecoveryCode returns a code like abcd-efgh-ijkl-mnop
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(recoveryEncoding.EncodeToString(b))
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// replaceRecoveryCodes discards the user's recovery codes and stores fresh ones.
+// Only hashes are kept, so this is the one time the codes are visible.
+func replaceRecoveryCodes(tx *sql.Tx, userID int64) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// checkSecondFactor accepts a TOTP code newer than the last one used, or an
+// unused recovery code, and records that it was used. The user row must be locked.
+func checkSecondFactor(tx *sql.Tx, userID int64, secret string, lastStep int64, code string) (bool, error) {
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		if step <= lastStep {
+			return false, nil
+		}
+		_, err := tx.Exec(`UPDATE users SET totp_last_step = $1 WHERE id = $2`, step, userID)
+		return err == nil, err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// TwoFactorStatus reports whether 2FA is on and how many recovery codes are left
+func TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var status models.TwoFactorStatus
+	err := database.DB.QueryRow(`
+		SELECT u.totp_enabled_at IS NOT NULL,
+			(SELECT COUNT(*) FROM recovery_codes WHERE user_id = u.id AND used_at IS NULL)
+		FROM users u
+		WHERE u.id = $1
+	`, userID).Scan(&status.Enabled, &status.RecoveryCodesRemaining)
+	if err != nil {
+		http.Error(w, "Failed to fetch 2FA status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// SetupTOTP generates a new secret for enrollment. It has no effect until
+// confirmed with EnableTOTP, so calling it again just starts over.
+func SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Failed to set up 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	var username string
+	err = database.DB.QueryRow(`
+		UPDATE users SET totp_secret = $1
+		WHERE id = $2 AND totp_enabled_at IS NULL
+		RETURNING username
+	`, secret, userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to set up 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TOTPSetupResponse{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(totpIssuer, username, secret),
+	})
+}
+
+// EnableTOTP turns on 2FA once the user proves their authenticator works,
+// and returns the recovery codes
+func EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to enable 2FA", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var secret sql.NullString
+	var enabled bool
+	err = tx.QueryRow(`
+		SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&secret, &enabled)
+	if err != nil {
+		http.Error(w, "Failed to enable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if !secret.Valid {
+		http.Error(w, "Set up 2FA before enabling it", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(secret.String, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $1 WHERE id = $2
+	`, step, userID); err != nil {
+		http.Error(w, "Failed to enable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to enable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to enable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// lockTwoFactor loads the user's 2FA state for update and answers 409 if it's off
+func lockTwoFactor(w http.ResponseWriter, tx *sql.Tx, userID int64) (secret string, lastStep int64, ok bool) {
+	var s sql.NullString
+	var enabled bool
+	err := tx.QueryRow(`
+		SELECT totp_secret, totp_last_step, totp_enabled_at IS NOT NULL
+		FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&s, &lastStep, &enabled)
+	if err != nil {
+		http.Error(w, "Failed to update 2FA", http.StatusInternalServerError)
+		return "", 0, false
+	}
+	if !enabled || !s.Valid {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return "", 0, false
+	}
+	return s.String, lastStep, true
+}
+
+// DisableTOTP turns off 2FA. It takes a current code (or a recovery code), so a
+// stolen session alone can't remove the second factor.
+func DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	secret, lastStep, ok := lockTwoFactor(w, tx, userID)
+	if !ok {
+		return
+	}
+
+	valid, err := checkSecondFactor(tx, userID, secret, lastStep, req.Code)
+	if err != nil {
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1
+	`, userID); err != nil {
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_challenges WHERE user_id = $1`, userID); err != nil {
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to disable 2FA", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"enabled": false}`))
+}
+
+// RegenerateRecoveryCodes replaces every recovery code. It takes a current TOTP code.
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	secret, lastStep, ok := lockTwoFactor(w, tx, userID)
+	if !ok {
+		return
+	}
+
+	step, valid := totp.Validate(secret, req.Code, time.Now())
+	if !valid || step <= lastStep {
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET totp_last_step = $1 WHERE id = $2`, step, userID); err != nil {
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyMFA completes a login that returned mfa_required. Each challenge
+// allows a handful of guesses before it is thrown away, and wrong codes count
+// towards the same lockout as wrong passwords.
+func VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "MFA token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var username, method string
+	var attempts int
+	err = tx.QueryRow(`
+		UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 AND expires_at > NOW()
+		RETURNING user_id, (SELECT username FROM users WHERE id = user_id), login_method, attempts
+	`, hashToken(req.MFAToken)).Scan(&userID, &username, &method, &attempts)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	// Starting a new challenge doesn't buy more guesses while locked out
+	ip := middleware.RealIP(r)
+	wait, err := loginLockedFor(username, ip)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeLockedOut(w, wait)
+		return
+	}
+
+	if attempts > maxMFAAttempts {
+		tx.Exec(`DELETE FROM mfa_challenges WHERE token_hash = $1`, hashToken(req.MFAToken))
+		tx.Commit()
+		http.Error(w, "Too many attempts, log in again", http.StatusUnauthorized)
+		return
+	}
+
+	var secret string
+	var lastStep int64
+	err = tx.QueryRow(`
+		SELECT totp_secret, totp_last_step FROM users
+		WHERE id = $1 AND totp_enabled_at IS NOT NULL
+		FOR UPDATE
+	`, userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		// 2FA was turned off after the challenge was issued
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	valid, err := checkSecondFactor(tx, userID, secret, lastStep, req.Code)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		// Keep the attempt count
+		tx.Commit()
+		if err := recordLoginFailure(username, ip); err != nil {
+			log.Println("Login failure tracking error:", err)
+		}
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_challenges WHERE token_hash = $1`, hashToken(req.MFAToken)); err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	var user models.User
+	err = tx.QueryRow(`
+		SELECT id, username, needs_username, created_at FROM users WHERE id = $1
+	`, userID).Scan(&user.ID, &user.Username, &user.NeedsUsername, &user.CreatedAt)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := startSession(r, userID, method)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	if err := clearLoginFailures(username); err != nil {
+		log.Println("Login failure tracking error:", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if req.UseCookie {
+		setAuthCookies(w, r, tokens)
+		json.NewEncoder(w).Encode(models.AuthResponse{ExpiresIn: int(accessTokenTTL.Seconds()), User: user})
+		return
+	}
+	json.NewEncoder(w).Encode(authResponse(tokens, user))
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/halva/songswap/internal/database"
+	"github.com/halva/songswap/internal/middleware"
+	"github.com/halva/songswap/internal/models"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 30
+	// numberedUsernameAttempts is how many name_2, name_3, ... we try before going random
+	numberedUsernameAttempts = 20
+)
+
+// validateUsername applies the registration rules and returns a message for the first one broken
+func validateUsername(username string) string {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return "Username must be between 3 and 30 characters"
+	}
+	for _, c := range username {
+		if !isUsernameChar(c) {
+			return "Username may only contain letters, numbers, dots, dashes and underscores"
+		}
+	}
+	return ""
+}
+
+func isUsernameChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.'
+}
+
+// sanitizeUsername turns a provider's display name into something validateUsername accepts
+func sanitizeUsername(raw, provider string) string {
+	var b strings.Builder
+	lastUnderscore := false
+	for _, c := range raw {
+		if isUsernameChar(c) && c != '_' {
+			b.WriteRune(c)
+			lastUnderscore = false
+		} else if !lastUnderscore {
+			b.WriteRune('_')
+			lastUnderscore = true
+		}
+	}
+
+	name := strings.Trim(b.String(), "_")
+	if len(name) > maxUsernameLength {
+		name = strings.TrimRight(name[:maxUsernameLength], "_")
+	}
+	if len(name) < minUsernameLength {
+		name = strings.Trim(name+"_"+provider, "_")
+	}
+	if len(name) < minUsernameLength {
+		name = "user"
+	}
+	return name
+}
+
+// withSuffix appends _suffix to base, shortening base so the result still fits
+func withSuffix(base, suffix string) string {
+	maxBase := maxUsernameLength - len(suffix) - 1
+	if len(base) > maxBase {
+		base = strings.TrimRight(base[:maxBase], "_")
+	}
+	return base + "_" + suffix
+}
+
+// usernameCandidates lists the names tried for a new OAuth user, in order:
+// the sanitized name, then numbered variants, then random ones
+func usernameCandidates(base string) []string {
+	candidates := []string{base}
+	for i := 2; i <= numberedUsernameAttempts; i++ {
+		candidates = append(candidates, withSuffix(base, strconv.Itoa(i)))
+	}
+	for i := 0; i < 5; i++ {
+		b := make([]byte, 3)
+		rand.Read(b)
+		candidates = append(candidates, withSuffix(base, hex.EncodeToString(b)))
+	}
+	return candidates
+}
+
+// createOAuthUser inserts a password-less user under the first free username candidate.
+// The user is flagged so they can pick their own username on first login.
+func createOAuthUser(providerUsername, provider string) (int64, error) {
+	var err error
+	for _, candidate := range usernameCandidates(sanitizeUsername(providerUsername, provider)) {
+		var userID int64
+		err = database.DB.QueryRow(
+			`INSERT INTO users (username, needs_username) VALUES ($1, true) RETURNING id`,
+			candidate,
+		).Scan(&userID)
+		if err == nil {
+			return userID, nil
+		}
+		if !database.IsUniqueViolation(err) {
+			return 0, err
+		}
+	}
+	return 0, err
+}
+
+// ChooseUsername lets a new OAuth user replace their generated username, once
+func ChooseUsername(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !requireScope(w, r, middleware.ScopeAccount) {
+		return
+	}
+
+	var req models.ChooseUsernameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateUsername(req.Username); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err := database.DB.QueryRow(`
+		UPDATE users SET username = $1, needs_username = false
+		WHERE id = $2 AND needs_username = true
+		RETURNING id, username, created_at
+	`, req.Username, userID).Scan(&user.ID, &user.Username, &user.CreatedAt)
+
+	if database.IsUniqueViolation(err) {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Username can only be chosen once", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,148 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config is the JSON file pointed to by JWT_KEYS_FILE, e.g.
+//
+//	{
+//	  "active": "2026-10",
+//	  "grace": "168h",
+//	  "keys": [
+//	    {"kid": "2026-10", "alg": "EdDSA", "private_key_file": "/run/secrets/jwt-2026-10.pem"},
+//	    {"kid": "default", "alg": "HS256", "secret_env": "JWT_SECRET", "retired_at": "2026-10-01T00:00:00Z"}
+//	  ]
+//	}
+type Config struct {
+	Active string      `json:"active"`
+	Legacy string      `json:"legacy,omitempty"`
+	Grace  string      `json:"grace,omitempty"`
+	Keys   []KeyConfig `json:"keys"`
+}
+
+type KeyConfig struct {
+	Kid            string     `json:"kid"`
+	Alg            string     `json:"alg"`
+	SecretEnv      string     `json:"secret_env,omitempty"`
+	PrivateKeyFile string     `json:"private_key_file,omitempty"`
+	PublicKeyFile  string     `json:"public_key_file,omitempty"`
+	RetiredAt      *time.Time `json:"retired_at,omitempty"`
+}
+
+// LoadFile builds a keyring from a JSON config file
+func LoadFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key config: %w", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing key config: %w", err)
+	}
+	return FromConfig(cfg)
+}
+
+func FromConfig(cfg Config) (*Keyring, error) {
+	var grace time.Duration
+	if cfg.Grace != "" {
+		var err error
+		if grace, err = time.ParseDuration(cfg.Grace); err != nil {
+			return nil, fmt.Errorf("invalid grace %q: %w", cfg.Grace, err)
+		}
+	}
+
+	kr := New(grace)
+	for _, kc := range cfg.Keys {
+		k, err := loadKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kc.Kid, err)
+		}
+		k.RetiredAt = kc.RetiredAt
+		if err := kr.Add(k); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := kr.SetActive(cfg.Active); err != nil {
+		return nil, err
+	}
+	if cfg.Legacy != "" {
+		if err := kr.SetLegacy(cfg.Legacy); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+func loadKey(kc KeyConfig) (*Key, error) {
+	if kc.Kid == "" {
+		return nil, fmt.Errorf("kid is required")
+	}
+
+	switch kc.Alg {
+	case "HS256":
+		secret := os.Getenv(kc.SecretEnv)
+		if kc.SecretEnv == "" || secret == "" {
+			return nil, fmt.Errorf("HS256 keys need a non-empty secret_env")
+		}
+		return NewHMACKey(kc.Kid, []byte(secret)), nil
+
+	case "EdDSA", "RS256":
+		if kc.PrivateKeyFile != "" {
+			priv, err := readPEM(kc.PrivateKeyFile, x509.ParsePKCS8PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			switch priv := priv.(type) {
+			case ed25519.PrivateKey:
+				if kc.Alg == "EdDSA" {
+					return NewEd25519Key(kc.Kid, priv), nil
+				}
+			case *rsa.PrivateKey:
+				if kc.Alg == "RS256" {
+					return NewRSAKey(kc.Kid, priv), nil
+				}
+			}
+			return nil, fmt.Errorf("private key does not match alg %s", kc.Alg)
+		}
+		if kc.PublicKeyFile != "" {
+			pub, err := readPEM(kc.PublicKeyFile, x509.ParsePKIXPublicKey)
+			if err != nil {
+				return nil, err
+			}
+			k, err := NewPublicKey(kc.Kid, pub)
+			if err != nil {
+				return nil, err
+			}
+			if k.Method.Alg() != kc.Alg {
+				return nil, fmt.Errorf("public key does not match alg %s", kc.Alg)
+			}
+			return k, nil
+		}
+		return nil, fmt.Errorf("%s keys need private_key_file or public_key_file", kc.Alg)
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Alg)
+	}
+}
+
+func readPEM(path string, parse func([]byte) (any, error)) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return parse(block.Bytes)
+}
@@ -0,0 +1,192 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AllowedAlgs is the strict allow-list of signing algorithms we verify.
+// Anything else, including "none", is rejected before a key is looked up.
+var AllowedAlgs = []string{"HS256", "RS256", "EdDSA"}
+
+var (
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrKeyRetired     = errors.New("signing key retired")
+	ErrAlgMismatch    = errors.New("token algorithm does not match key")
+	ErrNoActiveKey    = errors.New("no active signing key")
+	ErrCannotSign     = errors.New("key has no private part")
+	ErrDuplicateKey   = errors.New("duplicate key id")
+	ErrUnsupportedKey = errors.New("unsupported key type")
+)
+
+// Key is one signing or verification key, identified by its kid.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   any
+	VerifyKey any
+	// RetiredAt stops a key from signing; it keeps verifying for the keyring's grace window
+	RetiredAt *time.Time
+}
+
+func NewHMACKey(kid string, secret []byte) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodHS256, SignKey: secret, VerifyKey: secret}
+}
+
+func NewEd25519Key(kid string, priv ed25519.PrivateKey) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, SignKey: priv, VerifyKey: priv.Public()}
+}
+
+func NewRSAKey(kid string, priv *rsa.PrivateKey) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodRS256, SignKey: priv, VerifyKey: &priv.PublicKey}
+}
+
+// NewPublicKey builds a verify-only key from an Ed25519 or RSA public key
+func NewPublicKey(kid string, pub any) (*Key, error) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodEdDSA, VerifyKey: pub}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, VerifyKey: pub}, nil
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// Keyring holds every key we currently sign or verify with. One key is active
+// for signing; retired keys verify until their grace window runs out.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active string
+	legacy string
+	grace  time.Duration
+	now    func() time.Time
+}
+
+func New(grace time.Duration) *Keyring {
+	return &Keyring{
+		keys:  make(map[string]*Key),
+		grace: grace,
+		now:   time.Now,
+	}
+}
+
+// NewHMAC is the single-secret keyring used when only JWT_SECRET is configured.
+// The key also verifies tokens that predate kid headers.
+func NewHMAC(kid string, secret []byte) *Keyring {
+	kr := New(0)
+	kr.keys[kid] = NewHMACKey(kid, secret)
+	kr.active = kid
+	kr.legacy = kid
+	return kr
+}
+
+func (kr *Keyring) Add(k *Key) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, exists := kr.keys[k.ID]; exists {
+		return fmt.Errorf("%w: %s", ErrDuplicateKey, k.ID)
+	}
+	kr.keys[k.ID] = k
+	return nil
+}
+
+// SetActive picks the key new tokens are signed with
+func (kr *Keyring) SetActive(kid string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	k, ok := kr.keys[kid]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	if k.SignKey == nil {
+		return fmt.Errorf("%w: %s", ErrCannotSign, kid)
+	}
+	if k.RetiredAt != nil {
+		return fmt.Errorf("%w: %s", ErrKeyRetired, kid)
+	}
+	kr.active = kid
+	return nil
+}
+
+// SetLegacy picks the key that verifies tokens without a kid header
+func (kr *Keyring) SetLegacy(kid string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[kid]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	kr.legacy = kid
+	return nil
+}
+
+// Retire stops a key from signing and starts its grace window
+func (kr *Keyring) Retire(kid string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	k, ok := kr.keys[kid]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	now := kr.now()
+	k.RetiredAt = &now
+	if kr.active == kid {
+		kr.active = ""
+	}
+	return nil
+}
+
+// usable reports whether a key may still verify tokens
+func (kr *Keyring) usable(k *Key) bool {
+	return k.RetiredAt == nil || kr.now().Before(k.RetiredAt.Add(kr.grace))
+}
+
+// Sign issues a token with the active key and stamps its kid into the header
+func (kr *Keyring) Sign(claims jwt.Claims) (string, error) {
+	kr.mu.RLock()
+	k, ok := kr.keys[kr.active]
+	kr.mu.RUnlock()
+	if !ok {
+		return "", ErrNoActiveKey
+	}
+
+	token := jwt.NewWithClaims(k.Method, claims)
+	token.Header["kid"] = k.ID
+	return token.SignedString(k.SignKey)
+}
+
+// Keyfunc resolves the verification key for a token. It never trusts the
+// token's alg on its own: the alg must match the key the kid points at.
+func (kr *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = kr.legacy
+	}
+
+	k, ok := kr.keys[kid]
+	if !ok || kid == "" {
+		return nil, ErrUnknownKey
+	}
+	if !kr.usable(k) {
+		return nil, ErrKeyRetired
+	}
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, ErrAlgMismatch
+	}
+	return k.VerifyKey, nil
+}
+
+// Parse verifies a token string against the keyring and the algorithm allow-list
+func (kr *Keyring) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, kr.Keyfunc, jwt.WithValidMethods(AllowedAlgs))
+}
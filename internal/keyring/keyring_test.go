@@ -0,0 +1,132 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"user_id": float64(42),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestKeyring_SignAndVerify(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []*Key{
+		NewHMACKey("hs", []byte("secret")),
+		NewEd25519Key("ed", edPriv),
+		NewRSAKey("rs", rsaPriv),
+	}
+
+	for _, k := range keys {
+		t.Run(k.Method.Alg(), func(t *testing.T) {
+			kr := New(0)
+			if err := kr.Add(k); err != nil {
+				t.Fatal(err)
+			}
+			if err := kr.SetActive(k.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			tokenStr, err := kr.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := kr.Parse(tokenStr, jwt.MapClaims{})
+			if err != nil || !token.Valid {
+				t.Fatalf("expected valid token, got %v", err)
+			}
+			if token.Header["kid"] != k.ID {
+				t.Errorf("expected kid %q, got %v", k.ID, token.Header["kid"])
+			}
+		})
+	}
+}
+
+func TestKeyring_RotationGraceWindow(t *testing.T) {
+	kr := New(time.Hour)
+	kr.Add(NewHMACKey("old", []byte("old-secret")))
+	kr.Add(NewHMACKey("new", []byte("new-secret")))
+	kr.SetActive("old")
+
+	oldToken, _ := kr.Sign(testClaims())
+
+	kr.Retire("old")
+	kr.SetActive("new")
+
+	if _, err := kr.Parse(oldToken, jwt.MapClaims{}); err != nil {
+		t.Errorf("expected old token to verify during grace window, got %v", err)
+	}
+
+	// Jump past the grace window
+	kr.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := kr.Parse(oldToken, jwt.MapClaims{}); !errors.Is(err, ErrKeyRetired) {
+		t.Errorf("expected ErrKeyRetired, got %v", err)
+	}
+}
+
+func TestKeyring_RejectsAlgMismatch(t *testing.T) {
+	// Classic confusion attack: HMAC-sign with the Ed25519 public key under the Ed25519 kid
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	kr := New(0)
+	kr.Add(NewEd25519Key("ed", edPriv))
+	kr.SetActive("ed")
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "ed"
+	tokenStr, _ := forged.SignedString([]byte(edPub))
+
+	if _, err := kr.Parse(tokenStr, jwt.MapClaims{}); !errors.Is(err, ErrAlgMismatch) {
+		t.Errorf("expected ErrAlgMismatch, got %v", err)
+	}
+}
+
+func TestKeyring_RejectsAlgOutsideAllowList(t *testing.T) {
+	kr := NewHMAC("hs", []byte("secret"))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, testClaims())
+	token.Header["kid"] = "hs"
+	tokenStr, _ := token.SignedString([]byte("secret"))
+
+	if _, err := kr.Parse(tokenStr, jwt.MapClaims{}); err == nil {
+		t.Error("expected HS512 to be rejected")
+	}
+}
+
+func TestKeyring_UnknownKid(t *testing.T) {
+	kr := NewHMAC("hs", []byte("secret"))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	token.Header["kid"] = "missing"
+	tokenStr, _ := token.SignedString([]byte("secret"))
+
+	if _, err := kr.Parse(tokenStr, jwt.MapClaims{}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestKeyring_JWKSOmitsHMAC(t *testing.T) {
+	_, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+	kr := New(0)
+	kr.Add(NewHMACKey("hs", []byte("secret")))
+	kr.Add(NewEd25519Key("ed", edPriv))
+
+	set := kr.JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].Kid != "ed" || set.Keys[0].Kty != "OKP" {
+		t.Errorf("expected only the Ed25519 key, got %+v", set.Keys)
+	}
+}
@@ -0,0 +1,273 @@
+// Package live pairs up online users for anonymous real-time swaps. Two
+// queued players are matched at random, each has a time limit to pick a
+// song, and once both have, each is sent the other's.
+package live
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mathrand "math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+// DefaultTimeout is how long matched players have to pick a song
+const DefaultTimeout = 90 * time.Second
+
+// eventBuffer is how many undelivered events a player can have before new ones are dropped
+const eventBuffer = 16
+
+var (
+	ErrAlreadyQueued    = errors.New("live: already in the queue")
+	ErrNoMatch          = errors.New("live: no such match")
+	ErrAlreadySubmitted = errors.New("live: song already submitted")
+)
+
+// Event types, in the order a player usually sees them
+const (
+	EventQueued      = "queued"
+	EventMatched     = "matched"
+	EventReveal      = "reveal"
+	EventTimeout     = "timeout"
+	EventPartnerLeft = "partner_left"
+)
+
+// Event is sent to a player. The partner is never identified.
+type Event struct {
+	Type     string       `json:"-"`
+	MatchID  string       `json:"match_id,omitempty"`
+	Deadline *time.Time   `json:"deadline,omitempty"`
+	Song     *models.Song `json:"song,omitempty"`
+}
+
+// Player is a user taking part in one round: queued, matched, then revealed.
+// Events is closed when the round is over.
+type Player struct {
+	UserID int64
+	events chan Event
+	match  *match
+}
+
+// Events delivers the player's events until their round ends
+func (p *Player) Events() <-chan Event {
+	return p.events
+}
+
+// send never blocks the matcher; a client that stops reading loses events
+func (p *Player) send(ev Event) {
+	select {
+	case p.events <- ev:
+	default:
+	}
+}
+
+type match struct {
+	id      string
+	players [2]*Player
+	songs   [2]*models.Song
+	timer   *time.Timer
+}
+
+// Reveal describes a finished match: Users[i] sent Songs[i] and received the other one
+type Reveal struct {
+	MatchID string
+	Users   [2]int64
+	Songs   [2]models.Song
+}
+
+// Matcher holds the queue and running matches. It is in-process, so every
+// live player has to be connected to the same server.
+type Matcher struct {
+	Timeout time.Duration
+	// OnReveal, if set, is called after a match completes, outside the matcher's lock
+	OnReveal func(Reveal)
+
+	mu      sync.Mutex
+	queue   []*Player
+	players map[int64]*Player
+	matches map[string]*match
+}
+
+func NewMatcher(timeout time.Duration) *Matcher {
+	return &Matcher{
+		Timeout: timeout,
+		players: make(map[int64]*Player),
+		matches: make(map[string]*match),
+	}
+}
+
+// Join queues a user for a match. A user can only be in one round at a time.
+func (m *Matcher) Join(userID int64) (*Player, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.players[userID]; ok {
+		return nil, ErrAlreadyQueued
+	}
+
+	p := &Player{UserID: userID, events: make(chan Event, eventBuffer)}
+	m.players[userID] = p
+	m.enqueue(p)
+	return p, nil
+}
+
+// Leave takes a player out of the queue or their match. A partner left
+// waiting goes back into the queue.
+func (m *Matcher) Leave(p *Player) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The round may already be over, or the user may have started a new one
+	if m.players[p.UserID] != p {
+		return
+	}
+
+	for i, q := range m.queue {
+		if q == p {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+
+	if mt := p.match; mt != nil {
+		m.endMatch(mt)
+		partner := mt.players[0]
+		if partner == p {
+			partner = mt.players[1]
+		}
+		partner.send(Event{Type: EventPartnerLeft, MatchID: mt.id})
+		m.enqueue(partner)
+	}
+
+	m.finish(p)
+}
+
+// Check reports whether a user could submit a song to a match right now,
+// so callers can fail before doing any work
+func (m *Matcher) Check(userID int64, matchID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, _, err := m.lookup(userID, matchID)
+	return err
+}
+
+// Submit records a player's song. When it's the second song in, both players
+// are sent their partner's song and their round ends.
+func (m *Matcher) Submit(userID int64, matchID string, song models.Song) error {
+	m.mu.Lock()
+
+	mt, i, err := m.lookup(userID, matchID)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	mt.songs[i] = &song
+	if mt.songs[1-i] == nil {
+		m.mu.Unlock()
+		return nil
+	}
+
+	m.endMatch(mt)
+	reveal := Reveal{MatchID: mt.id}
+	for j, p := range mt.players {
+		reveal.Users[j] = p.UserID
+		reveal.Songs[j] = *mt.songs[j]
+		p.send(Event{Type: EventReveal, MatchID: mt.id, Song: mt.songs[1-j]})
+		m.finish(p)
+	}
+	m.mu.Unlock()
+
+	if m.OnReveal != nil {
+		m.OnReveal(reveal)
+	}
+	return nil
+}
+
+// lookup finds a user's match and their side of it; the caller holds m.mu
+func (m *Matcher) lookup(userID int64, matchID string) (*match, int, error) {
+	p := m.players[userID]
+	if p == nil || p.match == nil || p.match.id != matchID {
+		return nil, 0, ErrNoMatch
+	}
+
+	i := 0
+	if p.match.players[1] == p {
+		i = 1
+	}
+	if p.match.songs[i] != nil {
+		return nil, 0, ErrAlreadySubmitted
+	}
+	return p.match, i, nil
+}
+
+// enqueue adds a player to the queue and pairs whoever is waiting; the caller holds m.mu
+func (m *Matcher) enqueue(p *Player) {
+	p.match = nil
+	m.queue = append(m.queue, p)
+	p.send(Event{Type: EventQueued})
+
+	for len(m.queue) >= 2 {
+		// The longest-waiting player gets a random partner
+		a := m.queue[0]
+		j := 1 + mathrand.IntN(len(m.queue)-1)
+		b := m.queue[j]
+		m.queue = append(m.queue[1:j], m.queue[j+1:]...)
+
+		mt := &match{id: newMatchID(), players: [2]*Player{a, b}}
+		deadline := time.Now().Add(m.Timeout)
+		mt.timer = time.AfterFunc(m.Timeout, func() { m.expire(mt) })
+		m.matches[mt.id] = mt
+		a.match, b.match = mt, mt
+
+		ev := Event{Type: EventMatched, MatchID: mt.id, Deadline: &deadline}
+		a.send(ev)
+		b.send(ev)
+	}
+}
+
+// expire ends a match that ran out of time. Players who picked a song go back
+// into the queue; the ones who didn't are dropped.
+func (m *Matcher) expire(mt *match) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.matches[mt.id] != mt {
+		return
+	}
+
+	m.endMatch(mt)
+	for i, p := range mt.players {
+		p.send(Event{Type: EventTimeout, MatchID: mt.id})
+		if mt.songs[i] != nil {
+			m.enqueue(p)
+		} else {
+			m.finish(p)
+		}
+	}
+}
+
+// endMatch stops tracking a match; the caller holds m.mu
+func (m *Matcher) endMatch(mt *match) {
+	mt.timer.Stop()
+	delete(m.matches, mt.id)
+	for _, p := range mt.players {
+		p.match = nil
+	}
+}
+
+// finish ends a player's round and closes their events; the caller holds m.mu
+func (m *Matcher) finish(p *Player) {
+	delete(m.players, p.UserID)
+	close(p.events)
+}
+
+func newMatchID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,166 @@
+package live
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+// next returns the player's next event, failing if none arrives in time
+func next(t *testing.T, p *Player) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-p.Events():
+		if !ok {
+			t.Fatalf("user %d: events closed", p.UserID)
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatalf("user %d: no event", p.UserID)
+	}
+	return Event{}
+}
+
+func expectClosed(t *testing.T, p *Player) {
+	t.Helper()
+	select {
+	case ev, ok := <-p.Events():
+		if ok {
+			t.Fatalf("user %d: expected events to be closed, got %q", p.UserID, ev.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("user %d: events not closed", p.UserID)
+	}
+}
+
+// matchPair joins two users and returns them once they're matched
+func matchPair(t *testing.T, m *Matcher) (*Player, *Player, string) {
+	t.Helper()
+	a, err := m.Join(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.Join(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []*Player{a, b} {
+		if ev := next(t, p); ev.Type != EventQueued {
+			t.Fatalf("expected queued, got %q", ev.Type)
+		}
+	}
+	evA, evB := next(t, a), next(t, b)
+	if evA.Type != EventMatched || evB.Type != EventMatched || evA.MatchID != evB.MatchID {
+		t.Fatalf("expected both to be matched together, got %+v and %+v", evA, evB)
+	}
+	return a, b, evA.MatchID
+}
+
+func TestMatchAndReveal(t *testing.T) {
+	m := NewMatcher(time.Minute)
+	var reveal Reveal
+	m.OnReveal = func(r Reveal) { reveal = r }
+
+	a, b, matchID := matchPair(t, m)
+
+	if err := m.Submit(1, matchID, models.Song{ID: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Submit(1, matchID, models.Song{ID: 11}); !errors.Is(err, ErrAlreadySubmitted) {
+		t.Errorf("expected ErrAlreadySubmitted, got %v", err)
+	}
+	if err := m.Submit(2, matchID, models.Song{ID: 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ev := next(t, a); ev.Type != EventReveal || ev.Song.ID != 20 {
+		t.Errorf("expected user 1 to receive song 20, got %+v", ev)
+	}
+	if ev := next(t, b); ev.Type != EventReveal || ev.Song.ID != 10 {
+		t.Errorf("expected user 2 to receive song 10, got %+v", ev)
+	}
+	expectClosed(t, a)
+	expectClosed(t, b)
+
+	if reveal.MatchID != matchID || reveal.Users != [2]int64{1, 2} || reveal.Songs[0].ID != 10 || reveal.Songs[1].ID != 20 {
+		t.Errorf("unexpected reveal %+v", reveal)
+	}
+
+	// The round is over, so both can join again
+	if _, err := m.Join(1); err != nil {
+		t.Errorf("expected to rejoin, got %v", err)
+	}
+}
+
+func TestJoinTwice(t *testing.T) {
+	m := NewMatcher(time.Minute)
+	if _, err := m.Join(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Join(1); !errors.Is(err, ErrAlreadyQueued) {
+		t.Errorf("expected ErrAlreadyQueued, got %v", err)
+	}
+}
+
+func TestSubmitWrongMatch(t *testing.T) {
+	m := NewMatcher(time.Minute)
+	matchPair(t, m)
+
+	if err := m.Check(1, "nope"); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch, got %v", err)
+	}
+	if err := m.Submit(3, "nope", models.Song{ID: 1}); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch, got %v", err)
+	}
+}
+
+func TestTimeoutRequeuesSubmitter(t *testing.T) {
+	m := NewMatcher(20 * time.Millisecond)
+	a, b, matchID := matchPair(t, m)
+
+	if err := m.Submit(1, matchID, models.Song{ID: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ev := next(t, a); ev.Type != EventTimeout {
+		t.Fatalf("expected timeout, got %q", ev.Type)
+	}
+	if ev := next(t, a); ev.Type != EventQueued {
+		t.Errorf("expected submitter to be requeued, got %q", ev.Type)
+	}
+
+	if ev := next(t, b); ev.Type != EventTimeout {
+		t.Fatalf("expected timeout, got %q", ev.Type)
+	}
+	expectClosed(t, b)
+}
+
+func TestLeaveRequeuesPartner(t *testing.T) {
+	m := NewMatcher(time.Minute)
+	a, b, _ := matchPair(t, m)
+
+	m.Leave(a)
+	expectClosed(t, a)
+
+	if ev := next(t, b); ev.Type != EventPartnerLeft {
+		t.Fatalf("expected partner_left, got %q", ev.Type)
+	}
+	if ev := next(t, b); ev.Type != EventQueued {
+		t.Errorf("expected partner to be requeued, got %q", ev.Type)
+	}
+
+	// Leaving twice is harmless
+	m.Leave(a)
+
+	c, err := m.Join(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(t, c)
+	if ev := next(t, b); ev.Type != EventMatched {
+		t.Errorf("expected requeued partner to be matched again, got %q", ev.Type)
+	}
+}
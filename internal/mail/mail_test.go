@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{To: "a@example.com", Subject: "hi\r\nBcc: evil@example.com", Body: "line one\nline two"}
+	out := string(format("songswap <noreply@example.com>", msg, time.Unix(0, 0).UTC()))
+
+	if !strings.Contains(out, "To: a@example.com\r\n") {
+		t.Errorf("missing To header:\n%s", out)
+	}
+	if strings.Contains(out, "\r\nBcc:") {
+		t.Errorf("subject injected a header:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "\r\n\r\nline one\r\nline two") {
+		t.Errorf("unexpected body:\n%s", out)
+	}
+}
+
+func TestValidAddress(t *testing.T) {
+	cases := map[string]bool{
+		"a@example.com":           true,
+		"first.last@example.co":   true,
+		"":                        false,
+		"no-at-sign":              false,
+		"a@localhost":             false,
+		"Name <a@example.com>":    false,
+		"a@example.com\r\nBcc: x": false,
+	}
+	for addr, want := range cases {
+		if got := ValidAddress(addr); got != want {
+			t.Errorf("ValidAddress(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFile(dir, "songswap <noreply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(context.Background(), Message{To: "a@example.com", Subject: "reset", Body: "token"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "Subject: reset") {
+		t.Errorf("unexpected file contents:\n%s", data)
+	}
+}
+
+func TestNewSMTP_InvalidFrom(t *testing.T) {
+	if _, err := NewSMTP(SMTPConfig{Host: "localhost", Port: "25", From: "not an address"}); err == nil {
+		t.Error("expected error for invalid from address")
+	}
+}
@@ -0,0 +1,59 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTP sends email through a relay. Authentication is only attempted when a
+// username is set, and net/smtp refuses to send credentials without TLS.
+type SMTP struct {
+	cfg  SMTPConfig
+	addr string
+	// envelope is the bare From address used for MAIL FROM
+	envelope string
+}
+
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid from address %q: %w", cfg.From, err)
+	}
+	return &SMTP{cfg: cfg, addr: net.JoinHostPort(cfg.Host, cfg.Port), envelope: from.Address}, nil
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if !ValidAddress(msg.To) {
+		return fmt.Errorf("mail: invalid recipient %q", msg.To)
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	// net/smtp has no context support, so run it aside and give up on cancel
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.addr, auth, s.envelope, []string{msg.To}, format(s.cfg.From, msg, time.Now()))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
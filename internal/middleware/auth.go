@@ -6,29 +6,78 @@ import (
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/halva/songswap/internal/keyring"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "user_id"
+const (
+	UserIDKey    contextKey = "user_id"
+	SessionIDKey contextKey = "session_id"
+	// ScopesKey is only set for personal access tokens
+	ScopesKey contextKey = "scopes"
+)
+
+// AccessTokenCookie carries the access token for clients that opted into cookies
+const AccessTokenCookie = "songswap_access"
+
+// SessionChecker reports whether a session is still active. When set, tokens
+// must carry a session ID and are rejected once that session is revoked.
+var SessionChecker func(sessionID int64) (bool, error)
+
+func SetSessionChecker(checker func(sessionID int64) (bool, error)) {
+	SessionChecker = checker
+}
 
-func AuthMiddleware(secret []byte, next http.HandlerFunc) http.HandlerFunc {
+// APITokenPrefix marks personal access tokens, so they're never mistaken for JWTs
+const APITokenPrefix = "ssp_"
+
+// APITokenLookup resolves a personal access token to its user and scopes.
+// ok is false for unknown, revoked or expired tokens.
+var APITokenLookup func(token string) (userID int64, scopes []string, ok bool, err error)
+
+func SetAPITokenLookup(lookup func(token string) (int64, []string, bool, error)) {
+	APITokenLookup = lookup
+}
+
+func AuthMiddleware(keys *keyring.Keyring, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
+		var tokenString string
+		if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
+				return
+			}
+			tokenString = parts[1]
+		} else if cookie, err := r.Cookie(AccessTokenCookie); err == nil {
+			tokenString = cookie.Value
+		} else {
 			http.Error(w, "Authorization header required", http.StatusUnauthorized)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
+		if strings.HasPrefix(tokenString, APITokenPrefix) {
+			if APITokenLookup == nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			userID, scopes, ok, err := APITokenLookup(tokenString)
+			if err != nil {
+				http.Error(w, "Failed to verify token", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, ScopesKey, scopes)
+			next(w, r.WithContext(ctx))
 			return
 		}
 
-		token, err := jwt.Parse(parts[1], func(token *jwt.Token) (interface{}, error) {
-			return secret, nil
-		})
+		token, err := keys.Parse(tokenString, jwt.MapClaims{})
 		if err != nil || !token.Valid {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
@@ -42,6 +91,44 @@ func AuthMiddleware(secret []byte, next http.HandlerFunc) http.HandlerFunc {
 
 		userID := int64(claims["user_id"].(float64))
 		ctx := context.WithValue(r.Context(), UserIDKey, userID)
+
+		if sid, ok := claims["sid"].(float64); ok {
+			ctx = context.WithValue(ctx, SessionIDKey, int64(sid))
+		}
+
+		if SessionChecker != nil {
+			sessionID, ok := ctx.Value(SessionIDKey).(int64)
+			if !ok {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+			active, err := SessionChecker(sessionID)
+			if err != nil {
+				http.Error(w, "Failed to verify session", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				http.Error(w, "Session revoked", http.StatusUnauthorized)
+				return
+			}
+		}
+
 		next(w, r.WithContext(ctx))
 	}
-}
\ No newline at end of file
+}
+
+// OptionalAuth authenticates requests that carry a token, like AuthMiddleware,
+// and passes anonymous ones through without a user ID. A bad token is still
+// rejected rather than silently treated as anonymous.
+func OptionalAuth(keys *keyring.Keyring, next http.HandlerFunc) http.HandlerFunc {
+	auth := AuthMiddleware(keys, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if _, err := r.Cookie(AccessTokenCookie); err != nil {
+				next(w, r)
+				return
+			}
+		}
+		auth(w, r)
+	}
+}
@@ -7,12 +7,15 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/halva/songswap/internal/keyring"
 )
 
 var testSecret = []byte("test-secret-key")
 
+var testKeys = keyring.NewHMAC("test", testSecret)
+
 func TestAuthMiddleware_NoHeader(t *testing.T) {
-	handler := AuthMiddleware(testSecret, func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
@@ -27,7 +30,7 @@ func TestAuthMiddleware_NoHeader(t *testing.T) {
 }
 
 func TestAuthMiddleware_InvalidFormat(t *testing.T) {
-	handler := AuthMiddleware(testSecret, func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
@@ -49,7 +52,7 @@ func TestAuthMiddleware_ExpiredToken(t *testing.T) {
 	})
 	tokenStr, _ := token.SignedString(testSecret)
 
-	handler := AuthMiddleware(testSecret, func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
@@ -71,7 +74,7 @@ func TestAuthMiddleware_WrongSecret(t *testing.T) {
 	})
 	tokenStr, _ := token.SignedString([]byte("wrong-secret"))
 
-	handler := AuthMiddleware(testSecret, func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
@@ -94,7 +97,7 @@ func TestAuthMiddleware_ValidToken(t *testing.T) {
 	tokenStr, _ := token.SignedString(testSecret)
 
 	var gotUserID int64
-	handler := AuthMiddleware(testSecret, func(w http.ResponseWriter, r *http.Request) {
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
 		gotUserID = r.Context().Value(UserIDKey).(int64)
 		w.WriteHeader(http.StatusOK)
 	})
@@ -111,4 +114,201 @@ func TestAuthMiddleware_ValidToken(t *testing.T) {
 	if gotUserID != 42 {
 		t.Errorf("expected user_id 42, got %d", gotUserID)
 	}
-}
\ No newline at end of file
+}
+func TestAuthMiddleware_CookieToken(t *testing.T) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": float64(7),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	tokenStr, _ := token.SignedString(testSecret)
+
+	var gotUserID int64
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = r.Context().Value(UserIDKey).(int64)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: tokenStr})
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	if gotUserID != 7 {
+		t.Errorf("expected user_id 7, got %d", gotUserID)
+	}
+}
+
+func TestAuthMiddleware_RevokedSession(t *testing.T) {
+	SetSessionChecker(func(sessionID int64) (bool, error) {
+		return sessionID != 7, nil
+	})
+	defer SetSessionChecker(nil)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": float64(42),
+		"sid":     float64(7),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	tokenStr, _ := token.SignedString(testSecret)
+
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenStr)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestAuthMiddleware_ActiveSession(t *testing.T) {
+	SetSessionChecker(func(sessionID int64) (bool, error) {
+		return true, nil
+	})
+	defer SetSessionChecker(nil)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": float64(42),
+		"sid":     float64(8),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	tokenStr, _ := token.SignedString(testSecret)
+
+	var gotSessionID int64
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
+		gotSessionID = r.Context().Value(SessionIDKey).(int64)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenStr)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	if gotSessionID != 8 {
+		t.Errorf("expected session_id 8, got %d", gotSessionID)
+	}
+}
+
+func TestAuthMiddleware_MissingSessionID(t *testing.T) {
+	SetSessionChecker(func(sessionID int64) (bool, error) {
+		return true, nil
+	})
+	defer SetSessionChecker(nil)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": float64(42),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	tokenStr, _ := token.SignedString(testSecret)
+
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenStr)
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestAuthMiddleware_APIToken(t *testing.T) {
+	SetAPITokenLookup(func(token string) (int64, []string, bool, error) {
+		if token == APITokenPrefix+"good" {
+			return 9, []string{ScopeSongsWrite}, true, nil
+		}
+		return 0, nil, false, nil
+	})
+	defer SetAPITokenLookup(nil)
+	// API tokens have no session, so the checker must not be consulted
+	SetSessionChecker(func(sessionID int64) (bool, error) {
+		return false, nil
+	})
+	defer SetSessionChecker(nil)
+
+	var gotUserID int64
+	var gotScopes []string
+	handler := AuthMiddleware(testKeys, func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = r.Context().Value(UserIDKey).(int64)
+		gotScopes, _ = r.Context().Value(ScopesKey).([]string)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+APITokenPrefix+"good")
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", w.Code)
+	}
+	if gotUserID != 9 || len(gotScopes) != 1 || gotScopes[0] != ScopeSongsWrite {
+		t.Errorf("expected user 9 with songs:write, got %d %v", gotUserID, gotScopes)
+	}
+
+	req = httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+APITokenPrefix+"revoked")
+	w = httptest.NewRecorder()
+
+	handler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unknown token, got %d", w.Code)
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	var gotUser bool
+	handler := OptionalAuth(testKeys, func(w http.ResponseWriter, r *http.Request) {
+		_, gotUser = r.Context().Value(UserIDKey).(int64)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK || gotUser {
+		t.Errorf("expected an anonymous 200, got %d (user=%v)", w.Code, gotUser)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": float64(1),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	tokenStr, _ := token.SignedString(testSecret)
+
+	req = httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenStr)
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusOK || !gotUser {
+		t.Errorf("expected an authenticated 200, got %d (user=%v)", w.Code, gotUser)
+	}
+
+	req = httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer garbage")
+	w = httptest.NewRecorder()
+	handler(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected a bad token to get 401, got %d", w.Code)
+	}
+}
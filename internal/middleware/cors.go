@@ -6,7 +6,8 @@ import (
 	"strings"
 )
 
-func CORS(next http.Handler) http.Handler {
+// AllowedOrigins reads the frontend origins from CORS_ORIGINS
+func AllowedOrigins() map[string]bool {
 	allowedOrigins := make(map[string]bool)
 	origins := os.Getenv("CORS_ORIGINS")
 	if origins == "" {
@@ -15,14 +16,21 @@ func CORS(next http.Handler) http.Handler {
 	for _, o := range strings.Split(origins, ",") {
 		allowedOrigins[strings.TrimSpace(o)] = true
 	}
+	return allowedOrigins
+}
+
+func CORS(next http.Handler) http.Handler {
+	allowedOrigins := AllowedOrigins()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 		if allowedOrigins[origin] {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
+		// Lets the frontend tell a locked-out user how long to wait
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
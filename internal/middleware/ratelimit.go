@@ -62,7 +62,7 @@ func (rl *RateLimiter) cleanup() {
 
 func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := realIP(r)
+		ip := RealIP(r)
 
 		if !rl.getClient(ip).Allow() {
 			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
@@ -73,7 +73,8 @@ func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 	})
 }
 
-func realIP(r *http.Request) string {
+// RealIP returns the client IP, looking through the proxies we deploy behind
+func RealIP(r *http.Request) string {
 	// Cloudflare Tunnel sets this to the actual client IP
 	if ip := r.Header.Get("CF-Connecting-IP"); ip != "" {
 		return ip
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Scopes that can be granted to personal access tokens
+const (
+	ScopeSongsRead   = "songs:read"
+	ScopeSongsWrite  = "songs:write"
+	ScopeChainsRead  = "chains:read"
+	ScopeChainsWrite = "chains:write"
+	// ScopeRead grants every :read scope
+	ScopeRead = "read"
+)
+
+// ScopeAccount covers account management (passwords, sessions, tokens, 2FA).
+// It can't be granted, so only browser sessions have it.
+const ScopeAccount = "account"
+
+var GrantableScopes = []string{ScopeSongsRead, ScopeSongsWrite, ScopeChainsRead, ScopeChainsWrite, ScopeRead}
+
+// ValidScope reports whether a scope may be granted to a token
+func ValidScope(scope string) bool {
+	return slices.Contains(GrantableScopes, scope)
+}
+
+// HasScope reports whether the request may act with the given scope. Session
+// logins carry no scopes and may do anything; API tokens only what they were
+// granted, where resource:write implies resource:read.
+func HasScope(ctx context.Context, scope string) bool {
+	granted, ok := ctx.Value(ScopesKey).([]string)
+	if !ok {
+		return true
+	}
+
+	for _, g := range granted {
+		if g == scope {
+			return true
+		}
+		resource, action, found := strings.Cut(scope, ":")
+		if !found || action != "read" {
+			continue
+		}
+		if g == ScopeRead || g == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHasScope_Session(t *testing.T) {
+	// Session logins carry no scopes and can do anything, including account management
+	ctx := context.Background()
+	for _, scope := range []string{ScopeSongsWrite, ScopeChainsWrite, ScopeAccount} {
+		if !HasScope(ctx, scope) {
+			t.Errorf("expected session to have %s", scope)
+		}
+	}
+}
+
+func TestHasScope_Token(t *testing.T) {
+	cases := []struct {
+		granted []string
+		scope   string
+		want    bool
+	}{
+		{[]string{ScopeSongsWrite}, ScopeSongsWrite, true},
+		{[]string{ScopeSongsWrite}, ScopeSongsRead, true},
+		{[]string{ScopeSongsRead}, ScopeSongsWrite, false},
+		{[]string{ScopeSongsWrite}, ScopeChainsRead, false},
+		{[]string{ScopeRead}, ScopeChainsRead, true},
+		{[]string{ScopeRead}, ScopeChainsWrite, false},
+		{[]string{ScopeSongsWrite, ScopeChainsWrite}, ScopeAccount, false},
+		{[]string{}, ScopeSongsRead, false},
+	}
+	for _, c := range cases {
+		ctx := context.WithValue(context.Background(), ScopesKey, c.granted)
+		if got := HasScope(ctx, c.scope); got != c.want {
+			t.Errorf("HasScope(%v, %s) = %v, want %v", c.granted, c.scope, got, c.want)
+		}
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	if !ValidScope(ScopeChainsWrite) {
+		t.Error("expected chains:write to be grantable")
+	}
+	if ValidScope(ScopeAccount) {
+		t.Error("expected account scope not to be grantable")
+	}
+}
@@ -0,0 +1,113 @@
+package models
+
+import "time"
+
+// ExportProfile is the account itself as it appears in a data export
+type ExportProfile struct {
+	ID               int64      `json:"id"`
+	Username         string     `json:"username"`
+	DisplayName      *string    `json:"display_name"`
+	Bio              *string    `json:"bio"`
+	AvatarURL        *string    `json:"avatar_url"`
+	ShowSubmissions  bool       `json:"show_submissions"`
+	ShowLikes        bool       `json:"show_likes"`
+	Email            *string    `json:"email"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type ExportDiscovery struct {
+	Song         Song       `json:"song"`
+	Liked        *bool      `json:"liked"`
+	LikedAt      *time.Time `json:"liked_at"`
+	DiscoveredAt time.Time  `json:"discovered_at"`
+}
+
+// ExportChainAddition is a song the user added to any chain, their own or not
+type ExportChainAddition struct {
+	ChainID   int64     `json:"chain_id"`
+	ChainName string    `json:"chain_name"`
+	SongID    int64     `json:"song_id"`
+	SongURL   string    `json:"song_url"`
+	AddedAt   time.Time `json:"added_at"`
+}
+
+type ExportFollow struct {
+	ChainID    int64     `json:"chain_id"`
+	ChainName  string    `json:"chain_name"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// ExportSwap is a direct swap the user sent or received. SongID is left out of
+// a received swap until it's completed, the same as in the inbox.
+type ExportSwap struct {
+	ID          int64      `json:"id"`
+	Direction   string     `json:"direction"`
+	With        string     `json:"with"`
+	SongID      *int64     `json:"song_id,omitempty"`
+	Note        *string    `json:"note"`
+	ReplySongID *int64     `json:"reply_song_id,omitempty"`
+	ReplyNote   *string    `json:"reply_note"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+type ExportReaction struct {
+	SongID    int64     `json:"song_id"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportComment struct {
+	ID        int64     `json:"id"`
+	SongID    int64     `json:"song_id"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportNotification struct {
+	ID        int64      `json:"id"`
+	Kind      string     `json:"kind"`
+	SongID    int64      `json:"song_id"`
+	ChainID   *int64     `json:"chain_id,omitempty"`
+	Count     int        `json:"count"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// Export is everything we hold about a user, as returned by GET /me/export
+type Export struct {
+	ExportedAt     time.Time             `json:"exported_at"`
+	Profile        ExportProfile         `json:"profile"`
+	Submissions    []Song                `json:"submissions"`
+	Discoveries    []ExportDiscovery     `json:"discoveries"`
+	Chains         []Chain               `json:"chains"`
+	ChainAdditions []ExportChainAddition `json:"chain_additions"`
+	Follows        []ExportFollow        `json:"follows"`
+	LinkedAccounts []LinkedAccount       `json:"linked_accounts"`
+	Swaps          []ExportSwap          `json:"swaps"`
+	Reactions      []ExportReaction      `json:"reactions"`
+	Comments       []ExportComment       `json:"comments"`
+	Notifications  []ExportNotification  `json:"notifications"`
+}
+
+type DeleteAccountRequest struct {
+	// Password is required for accounts that have one
+	Password string `json:"password"`
+	// Confirm must be the username for accounts without a password
+	Confirm string `json:"confirm"`
+	// Code is a TOTP or recovery code, required when 2FA is on
+	Code string `json:"code"`
+	// Chains is "transfer" (the default) or "delete"
+	Chains string `json:"chains"`
+}
+
+type DeleteAccountResponse struct {
+	Deleted           bool `json:"deleted"`
+	ChainsTransferred int  `json:"chains_transferred"`
+	ChainsDeleted     int  `json:"chains_deleted"`
+}
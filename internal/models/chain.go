@@ -3,13 +3,18 @@ package models
 import "time"
 
 type Chain struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description,omitempty"`
-	CreatedBy   int64     `json:"created_by"`
-	CreatorName string    `json:"creator_name,omitempty"`
-	SongCount   int       `json:"song_count"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	Description    *string   `json:"description,omitempty"`
+	CreatedBy      int64     `json:"created_by"`
+	CreatorName    string    `json:"creator_name,omitempty"`
+	SongCount      int       `json:"song_count"`
+	ForkedFrom     *int64    `json:"forked_from,omitempty"`
+	ForkedFromName *string   `json:"forked_from_name,omitempty"`
+	ForkCount      int       `json:"fork_count"`
+	LikeCount      int       `json:"like_count"`
+	TrendingScore  float64   `json:"trending_score"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 type CreateChainRequest struct {
@@ -17,6 +22,49 @@ type CreateChainRequest struct {
 	Description *string `json:"description,omitempty"`
 }
 
+type ForkChainRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
 type AddChainSongRequest struct {
 	SongID int64 `json:"song_id"`
-}
\ No newline at end of file
+}
+
+type FollowedChain struct {
+	ChainID    int64     `json:"chain_id"`
+	ChainName  string    `json:"chain_name"`
+	Unread     int       `json:"unread"`
+	FollowedAt time.Time `json:"followed_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+type FeedItem struct {
+	ChainID   int64     `json:"chain_id"`
+	ChainName string    `json:"chain_name"`
+	Song      Song      `json:"song"`
+	AddedAt   time.Time `json:"added_at"`
+}
+
+type FeedResponse struct {
+	Chains []FollowedChain `json:"chains"`
+	Items  []FeedItem      `json:"items"`
+}
+
+type ChainContributor struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	SongCount int    `json:"song_count"`
+}
+
+type ChainTopSong struct {
+	Song  Song `json:"song"`
+	Likes int  `json:"likes"`
+}
+
+type ChainDetail struct {
+	Chain
+	Contributors   []ChainContributor `json:"contributors"`
+	TopSongs       []ChainTopSong     `json:"top_songs"`
+	LastActivityAt *time.Time         `json:"last_activity_at,omitempty"`
+}
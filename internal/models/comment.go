@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	CommentVisible = "visible"
+	// CommentHeld is waiting on moderation and only shown to its author
+	CommentHeld   = "held"
+	CommentHidden = "hidden"
+)
+
+// Comment is one message in a song's thread. Status is only set on the
+// author's own comments that others can't see.
+type Comment struct {
+	ID        int64     `json:"id"`
+	SongID    int64     `json:"song_id"`
+	Username  string    `json:"username"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status,omitempty"`
+	Mine      bool      `json:"mine"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateCommentRequest struct {
+	Body string `json:"body"`
+}
+
+type ReportCommentRequest struct {
+	Reason *string `json:"reason,omitempty"`
+}
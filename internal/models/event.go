@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// song-added events on a chain carry the Song itself, since chains are
+// public. On the pool they carry only this, so the pool can't be read
+// without discovering songs.
+type PoolSongEvent struct {
+	ID        int64     `json:"id"`
+	Platform  string    `json:"platform"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SongRemovedEvent struct {
+	SongID int64 `json:"song_id"`
+}
+
+// ChainUpdatedEvent says what changed; clients refetch the chain for details
+type ChainUpdatedEvent struct {
+	ChainID int64  `json:"chain_id"`
+	Change  string `json:"change"`
+}
+
+const (
+	ChainForked      = "forked"
+	ChainTransferred = "transferred"
+	ChainDeleted     = "deleted"
+)
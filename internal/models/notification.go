@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const (
+	NotifyDiscovered   = "discovered"
+	NotifyLiked        = "liked"
+	NotifyAddedToChain = "added_to_chain"
+)
+
+// Notification tells a submitter something happened to their song. Count is
+// how many times it happened since the notification was created; while it's
+// unread, repeats are added to it instead of creating new notifications.
+// Who discovered or liked the song is never included.
+type Notification struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Song      Song      `json:"song"`
+	ChainID   *int64    `json:"chain_id,omitempty"`
+	ChainName *string   `json:"chain_name,omitempty"`
+	Count     int       `json:"count"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type NotificationList struct {
+	Unread        int            `json:"unread"`
+	Notifications []Notification `json:"notifications"`
+}
+
+// MarkNotificationsReadRequest marks the given notifications read, or all of them when IDs is empty
+type MarkNotificationsReadRequest struct {
+	IDs []int64 `json:"ids,omitempty"`
+}
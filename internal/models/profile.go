@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type Profile struct {
+	Username        string    `json:"username"`
+	DisplayName     *string   `json:"display_name"`
+	Bio             *string   `json:"bio"`
+	AvatarURL       *string   `json:"avatar_url"`
+	JoinedAt        time.Time `json:"joined_at"`
+	SubmissionCount int       `json:"submission_count"`
+	ChainCount      int       `json:"chain_count"`
+	LikesReceived   int       `json:"likes_received"`
+	ShowSubmissions bool      `json:"show_submissions"`
+	ShowLikes       bool      `json:"show_likes"`
+}
+
+// UpdateProfileRequest is a partial update: nil fields are left alone and
+// an empty string clears a field
+type UpdateProfileRequest struct {
+	DisplayName     *string `json:"display_name"`
+	Bio             *string `json:"bio"`
+	AvatarURL       *string `json:"avatar_url"`
+	ShowSubmissions *bool   `json:"show_submissions"`
+	ShowLikes       *bool   `json:"show_likes"`
+}
+
+type LikedSong struct {
+	Song
+	LikedAt *time.Time `json:"liked_at"`
+}
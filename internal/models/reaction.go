@@ -0,0 +1,25 @@
+package models
+
+// Reactions is the fixed set of emoji users can react with
+var Reactions = []string{"🔥", "❤️", "😂", "😮", "😢", "👏", "🎶"}
+
+// ValidReaction reports whether emoji is one of Reactions
+func ValidReaction(emoji string) bool {
+	for _, r := range Reactions {
+		if r == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// ReactionSummary is a song's reaction counts and which ones are the caller's
+type ReactionSummary struct {
+	SongID int64          `json:"song_id"`
+	Counts map[string]int `json:"counts"`
+	Mine   []string       `json:"mine"`
+}
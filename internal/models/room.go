@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Room is a listening party on a chain
+type Room struct {
+	ID         string    `json:"id"`
+	ChainID    int64     `json:"chain_id"`
+	Host       string    `json:"host"`
+	Members    int       `json:"members"`
+	NowPlaying *Song     `json:"now_playing,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type RoomTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
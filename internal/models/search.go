@@ -0,0 +1,26 @@
+package models
+
+const (
+	SearchKindSong  = "song"
+	SearchKindChain = "chain"
+	SearchKindUser  = "user"
+)
+
+type SearchResult struct {
+	Kind     string  `json:"kind"`
+	ID       int64   `json:"id"`
+	Title    string  `json:"title"`
+	Subtitle *string `json:"subtitle,omitempty"`
+	Rank     float64 `json:"rank"`
+	Song     *Song   `json:"song,omitempty"`
+	Chain    *Chain  `json:"chain,omitempty"`
+}
+
+type SearchResponse struct {
+	Query  string         `json:"query"`
+	Songs  []SearchResult `json:"songs"`
+	Chains []SearchResult `json:"chains"`
+	Users  []SearchResult `json:"users"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type Session struct {
+	ID          int64     `json:"id"`
+	LoginMethod string    `json:"login_method"`
+	UserAgent   *string   `json:"user_agent,omitempty"`
+	IP          *string   `json:"ip,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	Current     bool      `json:"current"`
+}
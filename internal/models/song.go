@@ -9,6 +9,8 @@ type Song struct {
 	ContextCrumb *string   `json:"context_crumb,omitempty"`
 	SubmittedBy  *int64    `json:"submitted_by,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
+	// Reactions counts each emoji reacted with; absent when there are none
+	Reactions map[string]int `json:"reactions,omitempty"`
 }
 
 type SubmitSongRequest struct {
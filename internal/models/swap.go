@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+const (
+	SwapPending   = "pending"
+	SwapCompleted = "completed"
+	SwapExpired   = "expired"
+)
+
+// Swap is a song sent directly to one user. The recipient only sees the song
+// once they've sent one back, so Song is nil for them until then.
+type Swap struct {
+	ID          int64      `json:"id"`
+	Sender      string     `json:"sender"`
+	Recipient   string     `json:"recipient"`
+	Note        *string    `json:"note,omitempty"`
+	Song        *Song      `json:"song,omitempty"`
+	ReplySong   *Song      `json:"reply_song,omitempty"`
+	ReplyNote   *string    `json:"reply_note,omitempty"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// SwapSongRequest picks the song to send: an existing song by ID, or a new URL
+type SwapSongRequest struct {
+	SongID       *int64  `json:"song_id,omitempty"`
+	URL          string  `json:"url,omitempty"`
+	ContextCrumb *string `json:"context_crumb,omitempty"`
+	Note         *string `json:"note,omitempty"`
+}
+
+type CreateSwapRequest struct {
+	Recipient string `json:"recipient"`
+	SwapSongRequest
+}
+
+// LiveSongRequest picks the song for a live match, by ID or a new URL
+type LiveSongRequest struct {
+	SongID       *int64  `json:"song_id,omitempty"`
+	URL          string  `json:"url,omitempty"`
+	ContextCrumb *string `json:"context_crumb,omitempty"`
+}
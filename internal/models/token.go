@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// APIToken is a personal access token as listed to its owner. The secret is never included.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays of 0 means the token doesn't expire
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// CreateAPITokenResponse is the only time the token itself is shown
+type CreateAPITokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
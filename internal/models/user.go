@@ -3,10 +3,11 @@ package models
 import "time"
 
 type User struct {
-	ID           int64     `json:"id"`
-	Username     string    `json:"username"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID            int64     `json:"id"`
+	Username      string    `json:"username"`
+	PasswordHash  string    `json:"-"`
+	NeedsUsername bool      `json:"needs_username,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 type RegisterRequest struct {
@@ -14,12 +15,97 @@ type RegisterRequest struct {
 	Password string `json:"password"`
 }
 
+type ChooseUsernameRequest struct {
+	Username string `json:"username"`
+}
+
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 type AuthResponse struct {
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	User         User   `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ExchangeRequest struct {
+	Code string `json:"code"`
+	// UseCookie asks for the tokens as HttpOnly cookies instead of in the body
+	UseCookie bool `json:"use_cookie,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	// CurrentPassword may be empty for accounts that don't have a password yet
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type SetEmailRequest struct {
+	Email string `json:"email"`
+}
+
+type EmailStatus struct {
+	Email    *string `json:"email"`
+	Verified bool    `json:"verified"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type VerifyEmailRequest struct {
 	Token string `json:"token"`
-	User  User   `json:"user"`
-}
\ No newline at end of file
+}
+
+// MFAChallenge is returned by Login instead of tokens when the account has 2FA
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	// Code is a TOTP code or a recovery code
+	Code      string `json:"code"`
+	UseCookie bool   `json:"use_cookie,omitempty"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+type LinkedAccount struct {
+	Provider         string    `json:"provider"`
+	ProviderUsername string    `json:"provider_username"`
+	LinkedAt         time.Time `json:"linked_at"`
+}
+
+type LinkStartResponse struct {
+	URL string `json:"url"`
+}
@@ -0,0 +1,123 @@
+// Package moderation decides whether user-written text, such as a comment on
+// a song, is shown to others. The Moderator interface is the hook for plugging
+// in something smarter than the built-in word blocklist.
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Decision is what happens to a piece of text after review
+type Decision string
+
+const (
+	// Approve shows the text to everyone who can see the thread
+	Approve Decision = "visible"
+	// Hold keeps the text visible only to its author until someone reviews it
+	Hold Decision = "held"
+	// Reject refuses the text outright
+	Reject Decision = "rejected"
+)
+
+// Comment is the text under review and where it's being posted
+type Comment struct {
+	SongID int64
+	UserID int64
+	Body   string
+}
+
+// Moderator reviews comments before they're stored
+type Moderator interface {
+	Review(ctx context.Context, c Comment) (Decision, error)
+}
+
+// FromEnv picks a moderator: a blocklist read from COMMENT_BLOCKLIST_FILE, one
+// word or phrase per line, when it's set, otherwise one that approves everything
+func FromEnv() (Moderator, error) {
+	path := os.Getenv("COMMENT_BLOCKLIST_FILE")
+	if path == "" {
+		return AllowAll{}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open blocklist: %w", err)
+	}
+	defer f.Close()
+
+	var terms []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		terms = append(terms, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read blocklist: %w", err)
+	}
+	return NewBlocklist(terms), nil
+}
+
+// AllowAll approves every comment
+type AllowAll struct{}
+
+func (AllowAll) Review(ctx context.Context, c Comment) (Decision, error) {
+	return Approve, nil
+}
+
+// Blocklist holds comments that contain any of its terms as whole words,
+// ignoring case, so a person can look at them rather than rejecting outright
+type Blocklist struct {
+	terms [][]string
+}
+
+func NewBlocklist(terms []string) *Blocklist {
+	b := &Blocklist{}
+	for _, t := range terms {
+		if words := words(t); len(words) > 0 {
+			b.terms = append(b.terms, words)
+		}
+	}
+	return b
+}
+
+func (b *Blocklist) Review(ctx context.Context, c Comment) (Decision, error) {
+	body := words(c.Body)
+	for _, term := range b.terms {
+		if containsRun(body, term) {
+			return Hold, nil
+		}
+	}
+	return Approve, nil
+}
+
+// words lowercases s and splits it on anything that isn't a letter or digit
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsRun reports whether term appears in body as consecutive words
+func containsRun(body, term []string) bool {
+	for i := 0; i+len(term) <= len(body); i++ {
+		match := true
+		for j, w := range term {
+			if body[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
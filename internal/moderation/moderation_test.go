@@ -0,0 +1,61 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlocklist(t *testing.T) {
+	b := NewBlocklist([]string{"spam", "buy followers", "  "})
+
+	cases := map[string]Decision{
+		"great crumb":                   Approve,
+		"SPAM!":                         Hold,
+		"this is spammy but fine":       Approve,
+		"want to Buy   followers today": Hold,
+		"buy some followers":            Approve,
+	}
+	for body, want := range cases {
+		got, err := b.Review(context.Background(), Comment{Body: body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Review(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("COMMENT_BLOCKLIST_FILE", "")
+	m, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(AllowAll); !ok {
+		t.Errorf("expected AllowAll without a blocklist, got %T", m)
+	}
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("# comments are skipped\nspam\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("COMMENT_BLOCKLIST_FILE", path)
+	m, err = FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := m.Review(context.Background(), Comment{Body: "comments"}); d != Approve {
+		t.Errorf("expected the comment line not to be a term, got %q", d)
+	}
+	if d, _ := m.Review(context.Background(), Comment{Body: "spam"}); d != Hold {
+		t.Errorf("expected spam to be held, got %q", d)
+	}
+
+	t.Setenv("COMMENT_BLOCKLIST_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	if _, err := FromEnv(); err == nil {
+		t.Error("expected a missing blocklist file to fail")
+	}
+}
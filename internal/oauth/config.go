@@ -0,0 +1,113 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Presets are the providers we know out of the box. Each is enabled by
+// setting <NAME>_CLIENT_ID, <NAME>_CLIENT_SECRET and <NAME>_CALLBACK_URL.
+var Presets = map[string]OAuth2Config{
+	"discord": {
+		AuthEndpoint:   "https://discord.com/api/oauth2/authorize",
+		TokenEndpoint:  "https://discord.com/api/oauth2/token",
+		UserInfoURL:    "https://discord.com/api/users/@me",
+		Scopes:         []string{"identify"},
+		IDField:        "id",
+		UsernameFields: []string{"username"},
+		UsePKCE:        true,
+	},
+	"github": {
+		AuthEndpoint:   "https://github.com/login/oauth/authorize",
+		TokenEndpoint:  "https://github.com/login/oauth/access_token",
+		UserInfoURL:    "https://api.github.com/user",
+		Scopes:         []string{"read:user"},
+		IDField:        "id",
+		UsernameFields: []string{"login"},
+		UsePKCE:        true,
+	},
+	"google": {
+		Issuer:         "https://accounts.google.com",
+		Scopes:         []string{"openid", "profile"},
+		IDField:        "sub",
+		UsernameFields: []string{"given_name", "name"},
+		UsePKCE:        true,
+	},
+	"spotify": {
+		AuthEndpoint:   "https://accounts.spotify.com/authorize",
+		TokenEndpoint:  "https://accounts.spotify.com/api/token",
+		UserInfoURL:    "https://api.spotify.com/v1/me",
+		IDField:        "id",
+		UsernameFields: []string{"display_name", "id"},
+		UsePKCE:        true,
+	},
+}
+
+// FromEnv builds the registry from environment variables, plus any generic
+// providers listed in the JSON file named by OAUTH_PROVIDERS_FILE.
+func FromEnv(client *http.Client) (*Registry, error) {
+	reg := NewRegistry()
+
+	for name, preset := range Presets {
+		prefix := strings.ToUpper(name)
+		cfg := preset
+		cfg.Name = name
+		cfg.ClientID = os.Getenv(prefix + "_CLIENT_ID")
+		cfg.ClientSecret = os.Getenv(prefix + "_CLIENT_SECRET")
+		cfg.RedirectURL = os.Getenv(prefix + "_CALLBACK_URL")
+		if cfg.ClientID == "" || cfg.RedirectURL == "" {
+			continue
+		}
+
+		p, err := NewOAuth2(cfg, client)
+		if err != nil {
+			return nil, err
+		}
+		reg.Add(p)
+	}
+
+	if apiKey := os.Getenv("LASTFM_API_KEY"); apiKey != "" {
+		callbackURL := os.Getenv("LASTFM_CALLBACK_URL")
+		if callbackURL == "" {
+			return nil, fmt.Errorf("LASTFM_CALLBACK_URL is required with LASTFM_API_KEY")
+		}
+		reg.Add(NewLastfm(apiKey, os.Getenv("LASTFM_SHARED_SECRET"), callbackURL, client))
+	}
+
+	if path := os.Getenv("OAUTH_PROVIDERS_FILE"); path != "" {
+		if err := loadFile(reg, path, client); err != nil {
+			return nil, err
+		}
+	}
+
+	return reg, nil
+}
+
+// loadFile adds providers from a JSON array of OAuth2Config. Secrets are
+// never stored in the file; client_secret_env names the variable holding them.
+func loadFile(reg *Registry, path string, client *http.Client) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading provider config: %w", err)
+	}
+
+	var configs []OAuth2Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return fmt.Errorf("error parsing provider config: %w", err)
+	}
+
+	for _, cfg := range configs {
+		if cfg.SecretEnv != "" {
+			cfg.ClientSecret = os.Getenv(cfg.SecretEnv)
+		}
+		p, err := NewOAuth2(cfg, client)
+		if err != nil {
+			return err
+		}
+		reg.Add(p)
+	}
+	return nil
+}
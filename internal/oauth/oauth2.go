@@ -0,0 +1,223 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuth2Config describes a standard authorization-code provider. Endpoints
+// can be given directly or discovered from an OIDC issuer.
+type OAuth2Config struct {
+	Name          string   `json:"name"`
+	ClientID      string   `json:"client_id"`
+	ClientSecret  string   `json:"-"`
+	SecretEnv     string   `json:"client_secret_env,omitempty"`
+	RedirectURL   string   `json:"redirect_url"`
+	Issuer        string   `json:"issuer,omitempty"`
+	AuthEndpoint  string   `json:"auth_endpoint,omitempty"`
+	TokenEndpoint string   `json:"token_endpoint,omitempty"`
+	UserInfoURL   string   `json:"userinfo_endpoint,omitempty"`
+	Scopes        []string `json:"scopes"`
+	// IDField and UsernameFields name the userinfo JSON fields to read;
+	// the first non-empty username field wins
+	IDField        string   `json:"id_field"`
+	UsernameFields []string `json:"username_fields"`
+	UsePKCE        bool     `json:"pkce"`
+}
+
+// OAuth2 is a Provider for any OAuth2 or OIDC identity provider
+type OAuth2 struct {
+	cfg    OAuth2Config
+	client *http.Client
+}
+
+func NewOAuth2(cfg OAuth2Config, client *http.Client) (*OAuth2, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Name == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oauth provider needs name, client_id and redirect_url")
+	}
+	// Provider names are stored in VARCHAR(20) columns
+	if len(cfg.Name) > 20 {
+		return nil, fmt.Errorf("oauth provider name %q is over 20 characters", cfg.Name)
+	}
+	if cfg.IDField == "" {
+		cfg.IDField = "sub"
+	}
+	if len(cfg.UsernameFields) == 0 {
+		cfg.UsernameFields = []string{"preferred_username", "name", "email"}
+	}
+	if cfg.Issuer != "" && (cfg.AuthEndpoint == "" || cfg.TokenEndpoint == "" || cfg.UserInfoURL == "") {
+		if err := discover(&cfg, client); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AuthEndpoint == "" || cfg.TokenEndpoint == "" || cfg.UserInfoURL == "" {
+		return nil, fmt.Errorf("%s: missing endpoints and no issuer to discover them from", cfg.Name)
+	}
+	return &OAuth2{cfg: cfg, client: client}, nil
+}
+
+// discover fills missing endpoints from the issuer's OIDC discovery document
+func discover(cfg *OAuth2Config, client *http.Client) error {
+	resp, err := client.Get(strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return fmt.Errorf("%s: OIDC discovery failed: %w", cfg.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: OIDC discovery returned %d", cfg.Name, resp.StatusCode)
+	}
+
+	var doc struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("%s: invalid OIDC discovery document: %w", cfg.Name, err)
+	}
+
+	if cfg.AuthEndpoint == "" {
+		cfg.AuthEndpoint = doc.AuthorizationEndpoint
+	}
+	if cfg.TokenEndpoint == "" {
+		cfg.TokenEndpoint = doc.TokenEndpoint
+	}
+	if cfg.UserInfoURL == "" {
+		cfg.UserInfoURL = doc.UserinfoEndpoint
+	}
+	return nil
+}
+
+func (p *OAuth2) Name() string { return p.cfg.Name }
+
+func (p *OAuth2) PKCE() bool { return p.cfg.UsePKCE }
+
+func (p *OAuth2) AuthURL(state, challenge string) (string, error) {
+	u, err := url.Parse(p.cfg.AuthEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("response_type", "code")
+	query.Set("state", state)
+	if len(p.cfg.Scopes) > 0 {
+		query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	}
+	if p.cfg.UsePKCE {
+		query.Set("code_challenge", challenge)
+		query.Set("code_challenge_method", "S256")
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (p *OAuth2) Exchange(ctx context.Context, query url.Values, verifier string) (Identity, error) {
+	code := query.Get("code")
+	if code == "" {
+		return Identity{}, fmt.Errorf("%w: missing code", ErrExchangeFailed)
+	}
+
+	form := url.Values{
+		"client_id":     {p.cfg.ClientID},
+		"client_secret": {p.cfg.ClientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+	}
+	if p.cfg.UsePKCE {
+		form.Set("code_verifier", verifier)
+	}
+
+	tokenReq, err := http.NewRequestWithContext(ctx, "POST", p.cfg.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub answers form-encoded unless asked for JSON
+	tokenReq.Header.Set("Accept", "application/json")
+
+	var tokenData struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := p.doJSON(tokenReq, &tokenData); err != nil {
+		return Identity{}, err
+	}
+	if tokenData.AccessToken == "" {
+		return Identity{}, fmt.Errorf("%w: no access token", ErrExchangeFailed)
+	}
+
+	userReq, err := http.NewRequestWithContext(ctx, "GET", p.cfg.UserInfoURL, nil)
+	if err != nil {
+		return Identity{}, err
+	}
+	userReq.Header.Set("Authorization", "Bearer "+tokenData.AccessToken)
+	userReq.Header.Set("Accept", "application/json")
+
+	var info map[string]any
+	if err := p.doJSON(userReq, &info); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{ProviderUserID: field(info, p.cfg.IDField)}
+	for _, f := range p.cfg.UsernameFields {
+		if v := field(info, f); v != "" {
+			identity.ProviderUsername = v
+			break
+		}
+	}
+	if identity.ProviderUserID == "" {
+		return Identity{}, fmt.Errorf("%w: no %s in user info", ErrExchangeFailed, p.cfg.IDField)
+	}
+	if identity.ProviderUsername == "" {
+		identity.ProviderUsername = identity.ProviderUserID
+	}
+	return identity, nil
+}
+
+func (p *OAuth2) doJSON(req *http.Request, v any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrExchangeFailed, req.URL.Host, resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(body)))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrExchangeFailed, err)
+	}
+	return nil
+}
+
+// field reads a top-level userinfo field as a string; numeric IDs (GitHub) are kept exact
+func field(info map[string]any, name string) string {
+	switch v := info[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
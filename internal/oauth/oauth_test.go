@@ -0,0 +1,171 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// standIn is a fake identity provider serving discovery, token and userinfo endpoints
+func standIn(t *testing.T, userInfo map[string]any) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || r.Form.Get("client_id") != "client" ||
+			r.Form.Get("client_secret") != "secret" || r.Form.Get("grant_type") != "authorization_code" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		if r.Form.Get("code_verifier") != "verifier" {
+			http.Error(w, "bad verifier", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer"})
+	})
+
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(userInfo)
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOAuth2Presets(t *testing.T) {
+	tests := []struct {
+		preset       string
+		userInfo     map[string]any
+		wantID       string
+		wantUsername string
+	}{
+		{"discord", map[string]any{"id": "80351110224678912", "username": "nelly"}, "80351110224678912", "nelly"},
+		{"github", map[string]any{"id": 98765432101, "login": "octocat"}, "98765432101", "octocat"},
+		{"google", map[string]any{"sub": "110169484474386276334", "given_name": "Gina", "name": "Gina G"}, "110169484474386276334", "Gina"},
+		{"spotify", map[string]any{"id": "wizzler", "display_name": nil}, "wizzler", "wizzler"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			srv := standIn(t, tt.userInfo)
+
+			cfg := Presets[tt.preset]
+			cfg.Name = tt.preset
+			cfg.ClientID = "client"
+			cfg.ClientSecret = "secret"
+			cfg.RedirectURL = "https://songswap.test/auth/" + tt.preset + "/callback"
+			if cfg.Issuer != "" {
+				// OIDC presets find their endpoints through discovery
+				cfg.Issuer = srv.URL
+			} else {
+				cfg.AuthEndpoint = srv.URL + "/authorize"
+				cfg.TokenEndpoint = srv.URL + "/token"
+				cfg.UserInfoURL = srv.URL + "/userinfo"
+			}
+
+			p, err := NewOAuth2(cfg, srv.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			authURL, err := p.AuthURL("the-state", "the-challenge")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(authURL)
+			if !strings.HasPrefix(authURL, srv.URL+"/authorize?") {
+				t.Errorf("expected stand-in authorize endpoint, got %s", authURL)
+			}
+			if u.Query().Get("state") != "the-state" || u.Query().Get("code_challenge") != "the-challenge" {
+				t.Errorf("expected state and code challenge in %s", authURL)
+			}
+			if u.Query().Get("redirect_uri") != cfg.RedirectURL {
+				t.Errorf("expected redirect_uri %s, got %s", cfg.RedirectURL, u.Query().Get("redirect_uri"))
+			}
+
+			identity, err := p.Exchange(context.Background(), url.Values{"code": {"good-code"}}, "verifier")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.ProviderUserID != tt.wantID || identity.ProviderUsername != tt.wantUsername {
+				t.Errorf("got identity %+v, want id %s username %s", identity, tt.wantID, tt.wantUsername)
+			}
+
+			if _, err := p.Exchange(context.Background(), url.Values{"code": {"bad-code"}}, "verifier"); err == nil {
+				t.Error("expected a rejected code to fail")
+			}
+		})
+	}
+}
+
+func TestLastfm(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		params := map[string]string{"method": q.Get("method"), "api_key": q.Get("api_key"), "token": q.Get("token")}
+		if q.Get("token") != "good-token" || q.Get("api_sig") != lastfmSign(params, "shared") {
+			w.Write([]byte(`{"error": 4, "message": "Invalid authentication token supplied"}`))
+			return
+		}
+		w.Write([]byte(`{"session": {"name": "RJ", "key": "d580d57f32848f5dcf574d1ce18d78b2", "subscriber": 0}}`))
+	}))
+	defer srv.Close()
+
+	p := NewLastfm("key", "shared", "https://songswap.test/auth/lastfm/callback", srv.Client())
+	p.AuthPage = srv.URL + "/auth/"
+	p.APIURL = srv.URL + "/2.0/"
+
+	authURL, err := p.AuthURL("the-state", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	cb, _ := url.Parse(u.Query().Get("cb"))
+	if cb.Query().Get("state") != "the-state" {
+		t.Errorf("expected state in callback URL, got %s", cb)
+	}
+
+	identity, err := p.Exchange(context.Background(), url.Values{"token": {"good-token"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.ProviderUserID != "RJ" || identity.SessionKey == nil || *identity.SessionKey == "" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	if _, err := p.Exchange(context.Background(), url.Values{"token": {"bad-token"}}, ""); err == nil {
+		t.Error("expected a rejected token to fail")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry(NewLastfm("key", "shared", "https://songswap.test/cb", nil))
+	if _, err := reg.Get("lastfm"); err != nil {
+		t.Errorf("expected lastfm to be registered, got %v", err)
+	}
+	if _, err := reg.Get("myspace"); err != ErrUnknownProvider {
+		t.Errorf("expected ErrUnknownProvider, got %v", err)
+	}
+	var nilReg *Registry
+	if _, err := nilReg.Get("lastfm"); err != ErrUnknownProvider {
+		t.Errorf("expected a nil registry to know no providers, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown provider")
+	ErrExchangeFailed  = errors.New("provider rejected the login")
+)
+
+// Identity is who the provider says the user is
+type Identity struct {
+	ProviderUserID   string
+	ProviderUsername string
+	// SessionKey is kept for providers whose API we call later on the user's behalf (Last.fm)
+	SessionKey *string
+}
+
+// Provider is one external login. The callback pipeline in handlers is shared;
+// a provider only knows how to send the user away and how to read them back.
+type Provider interface {
+	Name() string
+	// PKCE reports whether AuthURL expects a code challenge
+	PKCE() bool
+	// AuthURL is where the browser is sent; state must come back on the callback
+	AuthURL(state, challenge string) (string, error)
+	// Exchange turns the callback's query into an identity
+	Exchange(ctx context.Context, query url.Values, verifier string) (Identity, error)
+}
+
+// Registry holds the configured providers by name
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	reg := &Registry{providers: make(map[string]Provider)}
+	for _, p := range providers {
+		reg.Add(p)
+	}
+	return reg
+}
+
+func (reg *Registry) Add(p Provider) {
+	reg.providers[p.Name()] = p
+}
+
+func (reg *Registry) Get(name string) (Provider, error) {
+	if reg == nil {
+		return nil, ErrUnknownProvider
+	}
+	p, ok := reg.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+// Names lists configured providers, sorted
+func (reg *Registry) Names() []string {
+	names := []string{}
+	if reg == nil {
+		return names
+	}
+	for name := range reg.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,659 @@
+// Package party runs listening parties: rooms tied to a chain where a host
+// controls playback and everyone else follows along. It knows nothing about
+// the transport; members read encoded messages from Outbox and pass the
+// commands they send to Room.Handle.
+package party
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+// TicketTTL is how long a ticket to join a room can be used for
+const TicketTTL = 30 * time.Second
+
+const (
+	// emptyRoomTTL is how long a room survives with nobody in it, so a host
+	// can reload the page without losing the party
+	emptyRoomTTL = 5 * time.Minute
+	maxMembers   = 100
+	maxQueue     = 50
+	// memberBuffer is how many messages a member can fall behind before being dropped
+	memberBuffer = 32
+	// reactionInterval throttles reactions per member; extra ones are ignored
+	reactionInterval = 250 * time.Millisecond
+)
+
+var (
+	ErrRoomNotFound   = errors.New("party: room not found")
+	ErrInvalidTicket  = errors.New("party: invalid or expired ticket")
+	ErrRoomFull       = errors.New("party: room is full")
+	ErrAlreadyHosting = errors.New("party: already hosting a room")
+)
+
+// Playable reports whether songs from a platform have an embedded player that
+// clients can start, pause and seek
+func Playable(platform string) bool {
+	return platform == "youtube" || platform == "spotify"
+}
+
+// SongSource looks up chain songs. ok is false when there's no such song in the chain.
+type SongSource interface {
+	ChainSong(chainID, songID int64) (song models.Song, ok bool, err error)
+	// NextChainSong returns the playable song after afterSongID in the order
+	// songs were added, wrapping around to the first
+	NextChainSong(chainID, afterSongID int64) (song models.Song, ok bool, err error)
+}
+
+// Commands members send
+const (
+	CmdPlay    = "play"
+	CmdPause   = "pause"
+	CmdSeek    = "seek"
+	CmdNext    = "next"
+	CmdEnd     = "end"
+	CmdReact   = "react"
+	CmdSuggest = "suggest"
+	CmdPing    = "ping"
+)
+
+// Messages members receive
+const (
+	MsgWelcome  = "welcome"
+	MsgState    = "state"
+	MsgQueue    = "queue"
+	MsgMembers  = "members"
+	MsgReaction = "reaction"
+	MsgPong     = "pong"
+	MsgError    = "error"
+	MsgClosed   = "closed"
+)
+
+type Command struct {
+	Type       string `json:"type"`
+	SongID     int64  `json:"song_id,omitempty"`
+	PositionMs int64  `json:"position_ms,omitempty"`
+	Emoji      string `json:"emoji,omitempty"`
+	ClientTime int64  `json:"client_time,omitempty"`
+}
+
+// Message is sent to members. ServerTime (Unix milliseconds) lets clients
+// estimate their clock offset and correct drift.
+type Message struct {
+	Type       string `json:"type"`
+	Data       any    `json:"data,omitempty"`
+	ServerTime int64  `json:"server_time"`
+}
+
+// State is the shared playback state. PositionMs is the position at
+// ServerTime; while playing, clients add the time elapsed since.
+type State struct {
+	Song       *models.Song `json:"song"`
+	Playing    bool         `json:"playing"`
+	PositionMs int64        `json:"position_ms"`
+	ServerTime int64        `json:"server_time"`
+}
+
+type StateChange struct {
+	Action string `json:"action"`
+	By     string `json:"by"`
+	State  State  `json:"state"`
+}
+
+type Suggestion struct {
+	Song        models.Song `json:"song"`
+	SuggestedBy string      `json:"suggested_by"`
+}
+
+type Reaction struct {
+	By    string `json:"by"`
+	Emoji string `json:"emoji"`
+}
+
+type Welcome struct {
+	Room    models.Room  `json:"room"`
+	State   State        `json:"state"`
+	Queue   []Suggestion `json:"queue"`
+	Members []string     `json:"members"`
+	Host    bool         `json:"host"`
+}
+
+// now is replaced in tests
+var now = time.Now
+
+func millis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+// Hub holds every open room and the tickets used to join them
+type Hub struct {
+	Songs SongSource
+
+	mu      sync.Mutex
+	rooms   map[string]*Room
+	tickets map[string]ticket
+}
+
+type ticket struct {
+	roomID   string
+	userID   int64
+	username string
+	expires  time.Time
+}
+
+func NewHub(songs SongSource) *Hub {
+	return &Hub{
+		Songs:   songs,
+		rooms:   make(map[string]*Room),
+		tickets: make(map[string]ticket),
+	}
+}
+
+// Create opens a room on a chain. A user can only host one room at a time.
+func (h *Hub) Create(chainID, hostID int64, host string) (*Room, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, r := range h.rooms {
+		if r.HostID == hostID {
+			return nil, ErrAlreadyHosting
+		}
+	}
+
+	r := &Room{
+		ID:        randomID(),
+		ChainID:   chainID,
+		HostID:    hostID,
+		Host:      host,
+		CreatedAt: now(),
+		hub:       h,
+		members:   make(map[*Member]bool),
+	}
+	// Nobody has joined yet, so the room closes if nobody does
+	r.emptyTimer = time.AfterFunc(emptyRoomTTL, r.closeIfEmpty)
+	h.rooms[r.ID] = r
+	return r, nil
+}
+
+func (h *Hub) Room(id string) (*Room, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[id]
+	return r, ok
+}
+
+// ChainRooms lists the open rooms on a chain
+func (h *Hub) ChainRooms(chainID int64) []models.Room {
+	h.mu.Lock()
+	var rooms []*Room
+	for _, r := range h.rooms {
+		if r.ChainID == chainID {
+			rooms = append(rooms, r)
+		}
+	}
+	h.mu.Unlock()
+
+	infos := []models.Room{}
+	for _, r := range rooms {
+		infos = append(infos, r.Info())
+	}
+	return infos
+}
+
+// IssueTicket lets an authenticated user join a room over a connection that
+// can't carry their credentials, such as a browser WebSocket
+func (h *Hub) IssueTicket(roomID string, userID int64, username string) (models.RoomTicket, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.rooms[roomID]; !ok {
+		return models.RoomTicket{}, ErrRoomNotFound
+	}
+
+	t := now()
+	for id, tk := range h.tickets {
+		if t.After(tk.expires) {
+			delete(h.tickets, id)
+		}
+	}
+
+	id := randomID()
+	expires := t.Add(TicketTTL)
+	h.tickets[id] = ticket{roomID: roomID, userID: userID, username: username, expires: expires}
+	return models.RoomTicket{Ticket: id, ExpiresAt: expires}, nil
+}
+
+// Join spends a ticket and adds its user to the room it was issued for
+func (h *Hub) Join(roomID, ticketID string) (*Room, *Member, error) {
+	h.mu.Lock()
+	tk, ok := h.tickets[ticketID]
+	delete(h.tickets, ticketID)
+	r := h.rooms[tk.roomID]
+	h.mu.Unlock()
+
+	if !ok || tk.roomID != roomID || now().After(tk.expires) {
+		return nil, nil, ErrInvalidTicket
+	}
+	if r == nil {
+		return nil, nil, ErrRoomNotFound
+	}
+
+	m := &Member{UserID: tk.userID, Username: tk.username, send: make(chan []byte, memberBuffer)}
+	if err := r.join(m); err != nil {
+		return nil, nil, err
+	}
+	return r, m, nil
+}
+
+func (h *Hub) remove(r *Room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.rooms[r.ID] == r {
+		delete(h.rooms, r.ID)
+	}
+}
+
+// Member is one connection to a room
+type Member struct {
+	UserID   int64
+	Username string
+
+	send         chan []byte
+	lastReaction time.Time
+}
+
+// Outbox delivers encoded messages for the member. It is closed when the
+// member leaves, falls too far behind, or the room closes.
+func (m *Member) Outbox() <-chan []byte {
+	return m.send
+}
+
+type Room struct {
+	ID        string
+	ChainID   int64
+	HostID    int64
+	Host      string
+	CreatedAt time.Time
+
+	hub        *Hub
+	mu         sync.Mutex
+	state      State
+	queue      []Suggestion
+	members    map[*Member]bool
+	closed     bool
+	emptyTimer *time.Timer
+}
+
+// Info summarizes the room for listings
+func (r *Room) Info() models.Room {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.infoLocked()
+}
+
+func (r *Room) infoLocked() models.Room {
+	return models.Room{
+		ID:         r.ID,
+		ChainID:    r.ChainID,
+		Host:       r.Host,
+		Members:    len(r.members),
+		NowPlaying: r.state.Song,
+		CreatedAt:  r.CreatedAt,
+	}
+}
+
+func (r *Room) join(m *Member) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrRoomNotFound
+	}
+	if len(r.members) >= maxMembers {
+		return ErrRoomFull
+	}
+
+	r.members[m] = true
+	if r.emptyTimer != nil {
+		r.emptyTimer.Stop()
+		r.emptyTimer = nil
+	}
+
+	r.sendLocked(m, MsgWelcome, Welcome{
+		Room:    r.infoLocked(),
+		State:   r.stateLocked(),
+		Queue:   r.queueLocked(),
+		Members: r.memberNamesLocked(),
+		Host:    m.UserID == r.HostID,
+	})
+	r.broadcastLocked(MsgMembers, r.memberNamesLocked())
+	return nil
+}
+
+// Leave removes a member. It is safe to call after the member was dropped.
+func (r *Room) Leave(m *Member) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.members[m] {
+		return
+	}
+	r.dropLocked(m)
+	r.broadcastLocked(MsgMembers, r.memberNamesLocked())
+}
+
+// Handle applies a command from a member and tells the room about it
+func (r *Room) Handle(m *Member, cmd Command) {
+	switch cmd.Type {
+	case CmdPing:
+		r.mu.Lock()
+		r.sendLocked(m, MsgPong, map[string]int64{"client_time": cmd.ClientTime})
+		r.mu.Unlock()
+	case CmdReact:
+		r.react(m, cmd.Emoji)
+	case CmdSuggest:
+		r.suggest(m, cmd.SongID)
+	case CmdPlay, CmdPause, CmdSeek, CmdNext, CmdEnd:
+		if m.UserID != r.HostID {
+			r.sendError(m, "Only the host can control playback")
+			return
+		}
+		r.control(m, cmd)
+	default:
+		r.sendError(m, "Unknown command")
+	}
+}
+
+func (r *Room) control(m *Member, cmd Command) {
+	switch cmd.Type {
+	case CmdPlay:
+		if cmd.SongID != 0 {
+			r.playSong(m, cmd.SongID, CmdPlay)
+			return
+		}
+		r.mu.Lock()
+		if r.state.Song == nil {
+			r.mu.Unlock()
+			r.next(m)
+			return
+		}
+		r.state.Playing = true
+		r.state.ServerTime = millis(now())
+		r.broadcastStateLocked(CmdPlay, m)
+		r.mu.Unlock()
+
+	case CmdPause:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.state.Song == nil {
+			r.sendLocked(m, MsgError, errorData("Nothing is playing"))
+			return
+		}
+		r.state = r.stateLocked()
+		r.state.Playing = false
+		r.broadcastStateLocked(CmdPause, m)
+
+	case CmdSeek:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.state.Song == nil {
+			r.sendLocked(m, MsgError, errorData("Nothing is playing"))
+			return
+		}
+		if cmd.PositionMs < 0 {
+			r.sendLocked(m, MsgError, errorData("Position can't be negative"))
+			return
+		}
+		r.state.PositionMs = cmd.PositionMs
+		r.state.ServerTime = millis(now())
+		r.broadcastStateLocked(CmdSeek, m)
+
+	case CmdNext:
+		if cmd.SongID != 0 {
+			r.playSong(m, cmd.SongID, CmdNext)
+			return
+		}
+		r.next(m)
+
+	case CmdEnd:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.closeLocked()
+	}
+}
+
+// next plays the first suggestion in the queue, or else the next song in the chain
+func (r *Room) next(m *Member) {
+	r.mu.Lock()
+	if len(r.queue) > 0 {
+		song := r.queue[0].Song
+		r.startLocked(song, CmdNext, m)
+		r.mu.Unlock()
+		return
+	}
+	var after int64
+	if r.state.Song != nil {
+		after = r.state.Song.ID
+	}
+	r.mu.Unlock()
+
+	song, ok, err := r.hub.Songs.NextChainSong(r.ChainID, after)
+	if err != nil {
+		r.sendError(m, "Failed to find the next song")
+		return
+	}
+	if !ok {
+		r.sendError(m, "This chain has no songs that can be played here")
+		return
+	}
+
+	r.mu.Lock()
+	r.startLocked(song, CmdNext, m)
+	r.mu.Unlock()
+}
+
+// playSong switches to a specific song from the chain
+func (r *Room) playSong(m *Member, songID int64, action string) {
+	song, ok := r.lookupPlayable(m, songID)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	r.startLocked(song, action, m)
+	r.mu.Unlock()
+}
+
+func (r *Room) suggest(m *Member, songID int64) {
+	song, ok := r.lookupPlayable(m, songID)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.queue) >= maxQueue {
+		r.sendLocked(m, MsgError, errorData("The queue is full"))
+		return
+	}
+	for _, s := range r.queue {
+		if s.Song.ID == song.ID {
+			r.sendLocked(m, MsgError, errorData("That song is already queued"))
+			return
+		}
+	}
+
+	r.queue = append(r.queue, Suggestion{Song: song, SuggestedBy: m.Username})
+	r.broadcastLocked(MsgQueue, r.queueLocked())
+}
+
+func (r *Room) react(m *Member, emoji string) {
+	if !models.ValidReaction(emoji) {
+		r.sendError(m, "Unknown reaction")
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := now()
+	if t.Sub(m.lastReaction) < reactionInterval {
+		return
+	}
+	m.lastReaction = t
+	r.broadcastLocked(MsgReaction, Reaction{By: m.Username, Emoji: emoji})
+}
+
+// lookupPlayable fetches a chain song, telling the member if it can't be played
+func (r *Room) lookupPlayable(m *Member, songID int64) (models.Song, bool) {
+	song, ok, err := r.hub.Songs.ChainSong(r.ChainID, songID)
+	if err != nil {
+		r.sendError(m, "Failed to find that song")
+		return song, false
+	}
+	if !ok {
+		r.sendError(m, "That song isn't in this chain")
+		return song, false
+	}
+	if !Playable(song.Platform) {
+		r.sendError(m, "Only YouTube and Spotify songs can be played in a room")
+		return song, false
+	}
+	return song, true
+}
+
+// startLocked plays a song from the start and takes it out of the queue
+func (r *Room) startLocked(song models.Song, action string, m *Member) {
+	r.state = State{Song: &song, Playing: true, PositionMs: 0, ServerTime: millis(now())}
+	r.broadcastStateLocked(action, m)
+
+	for i, s := range r.queue {
+		if s.Song.ID == song.ID {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			r.broadcastLocked(MsgQueue, r.queueLocked())
+			break
+		}
+	}
+}
+
+// stateLocked is the state brought forward to now
+func (r *Room) stateLocked() State {
+	s := r.state
+	t := millis(now())
+	if s.Playing {
+		s.PositionMs += t - s.ServerTime
+	}
+	s.ServerTime = t
+	return s
+}
+
+func (r *Room) queueLocked() []Suggestion {
+	return append([]Suggestion{}, r.queue...)
+}
+
+func (r *Room) memberNamesLocked() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for m := range r.members {
+		if !seen[m.Username] {
+			seen[m.Username] = true
+			names = append(names, m.Username)
+		}
+	}
+	return names
+}
+
+func (r *Room) broadcastStateLocked(action string, by *Member) {
+	r.broadcastLocked(MsgState, StateChange{Action: action, By: by.Username, State: r.state})
+}
+
+func (r *Room) sendError(m *Member, msg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sendLocked(m, MsgError, errorData(msg))
+}
+
+func errorData(msg string) map[string]string {
+	return map[string]string{"message": msg}
+}
+
+func (r *Room) sendLocked(m *Member, msgType string, data any) {
+	if !r.members[m] {
+		return
+	}
+	r.deliverLocked(m, encode(msgType, data))
+}
+
+func (r *Room) broadcastLocked(msgType string, data any) {
+	payload := encode(msgType, data)
+	for m := range r.members {
+		r.deliverLocked(m, payload)
+	}
+}
+
+// deliverLocked never blocks the room; a member that stops reading is dropped
+func (r *Room) deliverLocked(m *Member, payload []byte) {
+	select {
+	case m.send <- payload:
+	default:
+		r.dropLocked(m)
+	}
+}
+
+func (r *Room) dropLocked(m *Member) {
+	delete(r.members, m)
+	close(m.send)
+	if len(r.members) == 0 && !r.closed && r.emptyTimer == nil {
+		r.emptyTimer = time.AfterFunc(emptyRoomTTL, r.closeIfEmpty)
+	}
+}
+
+func (r *Room) closeIfEmpty() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.members) == 0 {
+		r.closeLocked()
+	}
+}
+
+func (r *Room) closeLocked() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	if r.emptyTimer != nil {
+		r.emptyTimer.Stop()
+	}
+
+	payload := encode(MsgClosed, nil)
+	for m := range r.members {
+		select {
+		case m.send <- payload:
+		default:
+		}
+		delete(r.members, m)
+		close(m.send)
+	}
+	r.hub.remove(r)
+}
+
+func encode(msgType string, data any) []byte {
+	payload, _ := json.Marshal(Message{Type: msgType, Data: data, ServerTime: millis(now())})
+	return payload
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,329 @@
+package party
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+// fakeSongs is a chain whose songs are kept in the order they were added
+type fakeSongs []models.Song
+
+func (f fakeSongs) ChainSong(chainID, songID int64) (models.Song, bool, error) {
+	for _, s := range f {
+		if s.ID == songID {
+			return s, true, nil
+		}
+	}
+	return models.Song{}, false, nil
+}
+
+func (f fakeSongs) NextChainSong(chainID, afterSongID int64) (models.Song, bool, error) {
+	var playable []models.Song
+	for _, s := range f {
+		if Playable(s.Platform) {
+			playable = append(playable, s)
+		}
+	}
+	if len(playable) == 0 {
+		return models.Song{}, false, nil
+	}
+	for i, s := range playable {
+		if s.ID == afterSongID {
+			return playable[(i+1)%len(playable)], true, nil
+		}
+	}
+	return playable[0], true, nil
+}
+
+var testChain = fakeSongs{
+	{ID: 1, Platform: "youtube"},
+	{ID: 2, Platform: "soundcloud"},
+	{ID: 3, Platform: "spotify"},
+}
+
+// setClock pins the package clock for the rest of the test
+func setClock(t *testing.T, at time.Time) *time.Time {
+	t.Helper()
+	clock := at
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+	return &clock
+}
+
+type received struct {
+	Type       string          `json:"type"`
+	Data       json.RawMessage `json:"data"`
+	ServerTime int64           `json:"server_time"`
+}
+
+// drain returns every message waiting for the member
+func drain(t *testing.T, m *Member) []received {
+	t.Helper()
+	var msgs []received
+	for {
+		select {
+		case payload, ok := <-m.Outbox():
+			if !ok {
+				return msgs
+			}
+			var msg received
+			if err := json.Unmarshal(payload, &msg); err != nil {
+				t.Fatal(err)
+			}
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// last returns the data of the last message of a type, failing if there was none
+func last(t *testing.T, msgs []received, msgType string, v any) {
+	t.Helper()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Type == msgType {
+			if err := json.Unmarshal(msgs[i].Data, v); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no %q message in %+v", msgType, msgs)
+}
+
+func join(t *testing.T, h *Hub, roomID string, userID int64, username string) *Member {
+	t.Helper()
+	tk, err := h.IssueTicket(roomID, userID, username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, m, err := h.Join(roomID, tk.Ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestTickets(t *testing.T) {
+	clock := setClock(t, time.Unix(1000, 0))
+	h := NewHub(testChain)
+	room, err := h.Create(7, 1, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.IssueTicket("missing", 1, "host"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("expected ErrRoomNotFound, got %v", err)
+	}
+
+	tk, _ := h.IssueTicket(room.ID, 2, "guest")
+	if _, _, err := h.Join("other-room", tk.Ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("expected a ticket for another room to fail, got %v", err)
+	}
+	// That attempt spent it
+	if _, _, err := h.Join(room.ID, tk.Ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("expected a ticket to be single-use, got %v", err)
+	}
+
+	tk, _ = h.IssueTicket(room.ID, 2, "guest")
+	*clock = clock.Add(TicketTTL + time.Second)
+	if _, _, err := h.Join(room.ID, tk.Ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("expected an expired ticket to fail, got %v", err)
+	}
+
+	if _, err := h.Create(8, 1, "host"); !errors.Is(err, ErrAlreadyHosting) {
+		t.Errorf("expected ErrAlreadyHosting, got %v", err)
+	}
+}
+
+func TestWelcomeAndMembers(t *testing.T) {
+	setClock(t, time.Unix(1000, 0))
+	h := NewHub(testChain)
+	room, _ := h.Create(7, 1, "host")
+
+	host := join(t, h, room.ID, 1, "host")
+	var welcome Welcome
+	last(t, drain(t, host), MsgWelcome, &welcome)
+	if !welcome.Host || welcome.Room.ChainID != 7 {
+		t.Errorf("unexpected welcome %+v", welcome)
+	}
+
+	guest := join(t, h, room.ID, 2, "guest")
+	last(t, drain(t, guest), MsgWelcome, &welcome)
+	if welcome.Host {
+		t.Error("expected guest not to be the host")
+	}
+
+	var members []string
+	last(t, drain(t, host), MsgMembers, &members)
+	if len(members) != 2 {
+		t.Errorf("expected 2 members, got %v", members)
+	}
+
+	room.Leave(guest)
+	last(t, drain(t, host), MsgMembers, &members)
+	if len(members) != 1 {
+		t.Errorf("expected 1 member after leaving, got %v", members)
+	}
+	if h.ChainRooms(7)[0].Members != 1 {
+		t.Errorf("expected the listing to show 1 member")
+	}
+}
+
+func TestPlaybackState(t *testing.T) {
+	clock := setClock(t, time.Unix(1000, 0))
+	h := NewHub(testChain)
+	room, _ := h.Create(7, 1, "host")
+	host := join(t, h, room.ID, 1, "host")
+	guest := join(t, h, room.ID, 2, "guest")
+	drain(t, host)
+	drain(t, guest)
+
+	// With nothing playing, play starts the chain from the top
+	room.Handle(host, Command{Type: CmdPlay})
+	var change StateChange
+	last(t, drain(t, guest), MsgState, &change)
+	if change.State.Song.ID != 1 || !change.State.Playing || change.By != "host" {
+		t.Fatalf("unexpected state %+v", change)
+	}
+
+	*clock = clock.Add(5 * time.Second)
+	room.Handle(host, Command{Type: CmdPause})
+	last(t, drain(t, guest), MsgState, &change)
+	if change.State.Playing || change.State.PositionMs != 5000 {
+		t.Errorf("expected to pause at 5000ms, got %+v", change.State)
+	}
+
+	*clock = clock.Add(time.Minute)
+	room.Handle(host, Command{Type: CmdSeek, PositionMs: 30000})
+	room.Handle(host, Command{Type: CmdPlay})
+	*clock = clock.Add(2 * time.Second)
+
+	// A late joiner gets the position brought forward
+	late := join(t, h, room.ID, 3, "late")
+	var welcome Welcome
+	last(t, drain(t, late), MsgWelcome, &welcome)
+	if welcome.State.PositionMs != 32000 || !welcome.State.Playing {
+		t.Errorf("expected a late joiner to start at 32000ms, got %+v", welcome.State)
+	}
+
+	// Next skips songs without a controllable player
+	room.Handle(host, Command{Type: CmdNext})
+	last(t, drain(t, guest), MsgState, &change)
+	if change.State.Song.ID != 3 || change.State.PositionMs != 0 {
+		t.Errorf("expected song 3 from the start, got %+v", change.State)
+	}
+}
+
+func TestOnlyHostControls(t *testing.T) {
+	setClock(t, time.Unix(1000, 0))
+	h := NewHub(testChain)
+	room, _ := h.Create(7, 1, "host")
+	host := join(t, h, room.ID, 1, "host")
+	guest := join(t, h, room.ID, 2, "guest")
+	drain(t, host)
+	drain(t, guest)
+
+	room.Handle(guest, Command{Type: CmdPlay})
+
+	msgs := drain(t, guest)
+	if len(msgs) != 1 || msgs[0].Type != MsgError {
+		t.Errorf("expected a single error, got %+v", msgs)
+	}
+	if msgs := drain(t, host); len(msgs) != 0 {
+		t.Errorf("expected the host to hear nothing, got %+v", msgs)
+	}
+}
+
+func TestSuggestionsQueue(t *testing.T) {
+	setClock(t, time.Unix(1000, 0))
+	h := NewHub(testChain)
+	room, _ := h.Create(7, 1, "host")
+	host := join(t, h, room.ID, 1, "host")
+	guest := join(t, h, room.ID, 2, "guest")
+	drain(t, host)
+	drain(t, guest)
+
+	room.Handle(guest, Command{Type: CmdSuggest, SongID: 2})
+	room.Handle(guest, Command{Type: CmdSuggest, SongID: 99})
+	for _, msg := range drain(t, guest) {
+		if msg.Type != MsgError {
+			t.Errorf("expected unplayable and unknown songs to be refused, got %q", msg.Type)
+		}
+	}
+
+	room.Handle(guest, Command{Type: CmdSuggest, SongID: 3})
+	var queue []Suggestion
+	last(t, drain(t, host), MsgQueue, &queue)
+	if len(queue) != 1 || queue[0].Song.ID != 3 || queue[0].SuggestedBy != "guest" {
+		t.Fatalf("unexpected queue %+v", queue)
+	}
+
+	// Next plays the suggestion before carrying on with the chain
+	room.Handle(host, Command{Type: CmdNext})
+	msgs := drain(t, guest)
+	var change StateChange
+	last(t, msgs, MsgState, &change)
+	last(t, msgs, MsgQueue, &queue)
+	if change.State.Song.ID != 3 || len(queue) != 0 {
+		t.Errorf("expected the suggestion to play and leave the queue, got %+v and %+v", change.State, queue)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	clock := setClock(t, time.Unix(1000, 0))
+	h := NewHub(testChain)
+	room, _ := h.Create(7, 1, "host")
+	host := join(t, h, room.ID, 1, "host")
+	guest := join(t, h, room.ID, 2, "guest")
+	drain(t, host)
+	drain(t, guest)
+
+	room.Handle(guest, Command{Type: CmdReact, Emoji: "🔥"})
+	room.Handle(guest, Command{Type: CmdReact, Emoji: "🔥"})
+	*clock = clock.Add(time.Second)
+	room.Handle(guest, Command{Type: CmdReact, Emoji: "👏"})
+	room.Handle(guest, Command{Type: CmdReact, Emoji: "💩"})
+
+	var reactions []string
+	for _, msg := range drain(t, host) {
+		if msg.Type == MsgReaction {
+			var r Reaction
+			json.Unmarshal(msg.Data, &r)
+			reactions = append(reactions, r.Emoji)
+		}
+	}
+	if len(reactions) != 2 || reactions[0] != "🔥" || reactions[1] != "👏" {
+		t.Errorf("expected throttled, valid reactions only, got %v", reactions)
+	}
+}
+
+func TestEndClosesRoom(t *testing.T) {
+	setClock(t, time.Unix(1000, 0))
+	h := NewHub(testChain)
+	room, _ := h.Create(7, 1, "host")
+	host := join(t, h, room.ID, 1, "host")
+	guest := join(t, h, room.ID, 2, "guest")
+	drain(t, host)
+	drain(t, guest)
+
+	room.Handle(host, Command{Type: CmdEnd})
+
+	msgs := drain(t, guest)
+	if len(msgs) != 1 || msgs[0].Type != MsgClosed {
+		t.Errorf("expected a closed message, got %+v", msgs)
+	}
+	if _, ok := <-guest.Outbox(); ok {
+		t.Error("expected the outbox to be closed")
+	}
+	if _, ok := h.Room(room.ID); ok {
+		t.Error("expected the room to be removed")
+	}
+
+	// Leaving after the room closed is harmless
+	room.Leave(guest)
+}
@@ -0,0 +1,217 @@
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+// similarityThreshold mirrors pg_trgm's default for the % operator
+const similarityThreshold = 0.3
+
+// Memory is an in-process Store with the same matching rules as Postgres:
+// every query word must appear in the document, or the trigram similarity of
+// the title must clear the threshold.
+type Memory struct {
+	mu     sync.RWMutex
+	songs  []models.Song
+	chains []models.Chain
+	users  []models.User
+	// discovered holds the IDs of the songs each user has discovered
+	discovered map[int64]map[int64]bool
+	// sent holds the IDs of songs that were only sent in swaps, not to the pool
+	sent map[int64]bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) AddSong(s models.Song) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.songs = append(m.songs, s)
+}
+
+// AddSentSong adds a song that was sent in a swap rather than to the pool
+func (m *Memory) AddSentSong(s models.Song) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sent == nil {
+		m.sent = make(map[int64]bool)
+	}
+	m.sent[s.ID] = true
+	m.songs = append(m.songs, s)
+}
+
+func (m *Memory) AddDiscovery(userID, songID int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.discovered == nil {
+		m.discovered = make(map[int64]map[int64]bool)
+	}
+	if m.discovered[userID] == nil {
+		m.discovered[userID] = make(map[int64]bool)
+	}
+	m.discovered[userID][songID] = true
+}
+
+func (m *Memory) AddChain(c models.Chain) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.chains = append(m.chains, c)
+}
+
+func (m *Memory) AddUser(u models.User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users = append(m.users, u)
+}
+
+func (m *Memory) Search(ctx context.Context, q Query) (models.SearchResponse, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	resp := models.SearchResponse{
+		Query:  q.Text,
+		Songs:  []models.SearchResult{},
+		Chains: []models.SearchResult{},
+		Users:  []models.SearchResult{},
+		Limit:  q.Limit,
+		Offset: q.Offset,
+	}
+	terms := words(q.Text)
+
+	if q.wants(models.SearchKindSong) {
+		for _, s := range m.songs {
+			crumb := ""
+			if s.ContextCrumb != nil {
+				crumb = *s.ContextCrumb
+			}
+			opened := m.discovered[q.UserID][s.ID] || (s.SubmittedBy != nil && *s.SubmittedBy == q.UserID)
+			if m.sent[s.ID] && !opened {
+				continue
+			}
+			document := crumb + " " + s.Platform
+			if opened {
+				document += " " + s.URL
+			}
+			if rank, ok := match(terms, q.Text, document, crumb); ok {
+				resp.Songs = append(resp.Songs, songResult(s, opened, rank))
+			}
+		}
+		resp.Songs = page(resp.Songs, q)
+	}
+
+	if q.wants(models.SearchKindChain) {
+		for _, c := range m.chains {
+			description := ""
+			if c.Description != nil {
+				description = *c.Description
+			}
+			if rank, ok := match(terms, q.Text, c.Name+" "+description, c.Name); ok {
+				resp.Chains = append(resp.Chains, chainResult(c, rank))
+			}
+		}
+		resp.Chains = page(resp.Chains, q)
+	}
+
+	if q.wants(models.SearchKindUser) {
+		for _, u := range m.users {
+			sim := similarity(u.Username, q.Text)
+			contains := strings.Contains(strings.ToLower(u.Username), strings.ToLower(q.Text))
+			if !contains && sim < similarityThreshold {
+				continue
+			}
+			rank := sim
+			if strings.EqualFold(u.Username, q.Text) {
+				rank++
+			}
+			resp.Users = append(resp.Users, userResult(u, rank))
+		}
+		resp.Users = page(resp.Users, q)
+	}
+
+	return resp, nil
+}
+
+// match checks a document against the query and returns its rank
+func match(terms []string, text, document, title string) (float64, bool) {
+	sim := similarity(title, text)
+
+	docWords := make(map[string]bool)
+	for _, w := range words(document) {
+		docWords[w] = true
+	}
+	all := len(terms) > 0
+	for _, t := range terms {
+		if !docWords[t] {
+			all = false
+			break
+		}
+	}
+
+	if !all && sim < similarityThreshold {
+		return 0, false
+	}
+	rank := sim
+	if all {
+		rank += 0.1
+	}
+	return rank, true
+}
+
+func page(results []models.SearchResult, q Query) []models.SearchResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].ID > results[j].ID
+	})
+	if q.Offset >= len(results) {
+		return []models.SearchResult{}
+	}
+	results = results[q.Offset:]
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results
+}
+
+// words lowercases s and splits it on anything that is not a letter or digit
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// trigrams follows pg_trgm: each word is padded with two leading spaces and one trailing
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range words(s) {
+		padded := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// similarity is the share of trigrams two strings have in common, as in pg_trgm
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(ta)+len(tb)-common)
+}
@@ -0,0 +1,130 @@
+package search
+
+import (
+	"context"
+	"testing"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+func strPtr(s string) *string { return &s }
+
+func newTestStore() *Memory {
+	m := NewMemory()
+	m.AddSong(models.Song{ID: 1, URL: "https://youtube.com/watch?v=a", Platform: "youtube", ContextCrumb: strPtr("3am song")})
+	m.AddSong(models.Song{ID: 2, URL: "https://open.spotify.com/track/b", Platform: "spotify", ContextCrumb: strPtr("play this loud")})
+	m.AddChain(models.Chain{ID: 1, Name: "rainy day", Description: strPtr("songs for the rain")})
+	m.AddChain(models.Chain{ID: 2, Name: "guilty pleasures"})
+	m.AddUser(models.User{ID: 1, Username: "halva"})
+	m.AddUser(models.User{ID: 2, Username: "halvard"})
+	return m
+}
+
+func TestMemorySearch_FullText(t *testing.T) {
+	resp, err := newTestStore().Search(context.Background(), Query{Text: "loud", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Songs) != 1 || resp.Songs[0].ID != 2 {
+		t.Errorf("expected song 2, got %+v", resp.Songs)
+	}
+	if len(resp.Chains) != 0 {
+		t.Errorf("expected no chains, got %+v", resp.Chains)
+	}
+}
+
+func TestMemorySearch_TrigramFallback(t *testing.T) {
+	// "guilty pleasurs" has a typo, so only trigram similarity can find it
+	resp, err := newTestStore().Search(context.Background(), Query{Text: "guilty pleasurs", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Chains) != 1 || resp.Chains[0].ID != 2 {
+		t.Errorf("expected chain 2, got %+v", resp.Chains)
+	}
+}
+
+func TestMemorySearch_ExactUsernameRanksFirst(t *testing.T) {
+	resp, err := newTestStore().Search(context.Background(), Query{Text: "halva", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 2 || resp.Users[0].Title != "halva" {
+		t.Errorf("expected halva first, got %+v", resp.Users)
+	}
+}
+
+func TestMemorySearch_KindsAndPaging(t *testing.T) {
+	q := Query{Text: "halva", Kinds: map[string]bool{models.SearchKindUser: true}, Limit: 1, Offset: 1}
+	resp, err := newTestStore().Search(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Title != "halvard" {
+		t.Errorf("expected only halvard on page 2, got %+v", resp.Users)
+	}
+	if len(resp.Songs) != 0 || len(resp.Chains) != 0 {
+		t.Error("expected other kinds to be skipped")
+	}
+}
+
+func TestMemorySearch_HidesUndiscoveredSongs(t *testing.T) {
+	m := newTestStore()
+
+	resp, err := m.Search(context.Background(), Query{Text: "3am", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Songs) != 1 || resp.Songs[0].Song != nil || resp.Songs[0].Title != "3am song" {
+		t.Errorf("expected song 1 without its URL, got %+v", resp.Songs)
+	}
+
+	// Words from the URL only match once the song has been discovered
+	resp, err = m.Search(context.Background(), Query{Text: "youtube watch", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Songs) != 0 {
+		t.Errorf("expected no songs, got %+v", resp.Songs)
+	}
+
+	m.AddDiscovery(5, 1)
+	resp, err = m.Search(context.Background(), Query{Text: "youtube watch", Limit: 10, UserID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Songs) != 1 || resp.Songs[0].Song == nil || resp.Songs[0].Song.URL != "https://youtube.com/watch?v=a" {
+		t.Errorf("expected song 1 with its URL, got %+v", resp.Songs)
+	}
+}
+
+func TestMemorySearch_SkipsSentSongs(t *testing.T) {
+	m := newTestStore()
+	sender := int64(3)
+	m.AddSentSong(models.Song{ID: 3, URL: "https://youtube.com/watch?v=c", Platform: "youtube", ContextCrumb: strPtr("just for you"), SubmittedBy: &sender})
+
+	resp, err := m.Search(context.Background(), Query{Text: "just for you", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Songs) != 0 {
+		t.Errorf("expected no songs, got %+v", resp.Songs)
+	}
+
+	resp, err = m.Search(context.Background(), Query{Text: "just for you", Limit: 10, UserID: sender})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Songs) != 1 || resp.Songs[0].Song == nil {
+		t.Errorf("expected the sender to find song 3, got %+v", resp.Songs)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if s := similarity("word", "word"); s != 1 {
+		t.Errorf("expected identical strings to score 1, got %f", s)
+	}
+	if s := similarity("abc", "xyz"); s != 0 {
+		t.Errorf("expected disjoint strings to score 0, got %f", s)
+	}
+}
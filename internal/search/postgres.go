@@ -0,0 +1,182 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+// Postgres searches with full-text queries and falls back to pg_trgm similarity
+// so that typos still find something. Expressions must match migration 007's indexes.
+type Postgres struct {
+	DB *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{DB: db}
+}
+
+func (p *Postgres) Search(ctx context.Context, q Query) (models.SearchResponse, error) {
+	resp := models.SearchResponse{
+		Query:  q.Text,
+		Songs:  []models.SearchResult{},
+		Chains: []models.SearchResult{},
+		Users:  []models.SearchResult{},
+		Limit:  q.Limit,
+		Offset: q.Offset,
+	}
+
+	var err error
+	if q.wants(models.SearchKindSong) {
+		if resp.Songs, err = p.songs(ctx, q); err != nil {
+			return resp, err
+		}
+	}
+	if q.wants(models.SearchKindChain) {
+		if resp.Chains, err = p.chains(ctx, q); err != nil {
+			return resp, err
+		}
+	}
+	if q.wants(models.SearchKindUser) {
+		if resp.Users, err = p.users(ctx, q); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// songs matches URLs only for songs the caller can already open, so that
+// searching can't be used to find out what's in the pool. Songs sent in swaps
+// aren't in the pool and only turn up for the people who have them.
+func (p *Postgres) songs(ctx context.Context, q Query) ([]models.SearchResult, error) {
+	rows, err := p.DB.QueryContext(ctx, `
+		WITH query AS (SELECT websearch_to_tsquery('simple', $1) AS tsq)
+		SELECT s.id, s.url, s.platform, s.context_crumb, s.created_at, o.opened,
+			ts_rank(to_tsvector('simple', coalesce(s.context_crumb, '') || ' ' || s.platform || ' ' || s.url), query.tsq)
+				+ similarity(coalesce(s.context_crumb, ''), $1) AS rank
+		FROM songs s
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(s.submitted_by = $4, false)
+				OR EXISTS(SELECT 1 FROM discoveries d WHERE d.song_id = s.id AND d.user_id = $4) AS opened
+		) o, query
+		WHERE (s.in_pool OR o.opened)
+		AND ((to_tsvector('simple', coalesce(s.context_crumb, '') || ' ' || s.platform || ' ' || s.url) @@ query.tsq
+			AND (o.opened OR to_tsvector('simple', coalesce(s.context_crumb, '') || ' ' || s.platform) @@ query.tsq))
+		OR coalesce(s.context_crumb, '') % $1)
+		ORDER BY rank DESC, s.id DESC
+		LIMIT $2 OFFSET $3
+	`, q.Text, q.Limit, q.Offset, q.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var s models.Song
+		var opened bool
+		var rank float64
+		if err := rows.Scan(&s.ID, &s.URL, &s.Platform, &s.ContextCrumb, &s.CreatedAt, &opened, &rank); err != nil {
+			continue
+		}
+		results = append(results, songResult(s, opened, rank))
+	}
+	return results, rows.Err()
+}
+
+func (p *Postgres) chains(ctx context.Context, q Query) ([]models.SearchResult, error) {
+	rows, err := p.DB.QueryContext(ctx, `
+		WITH query AS (SELECT websearch_to_tsquery('simple', $1) AS tsq)
+		SELECT c.id, c.name, c.description, c.created_by, u.username, c.created_at,
+			ts_rank(to_tsvector('simple', c.name || ' ' || coalesce(c.description, '')), query.tsq)
+				+ similarity(c.name, $1) AS rank
+		FROM chains c
+		JOIN users u ON c.created_by = u.id, query
+		WHERE to_tsvector('simple', c.name || ' ' || coalesce(c.description, '')) @@ query.tsq
+		OR c.name % $1
+		ORDER BY rank DESC, c.id DESC
+		LIMIT $2 OFFSET $3
+	`, q.Text, q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var c models.Chain
+		var rank float64
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.CreatedBy, &c.CreatorName, &c.CreatedAt, &rank); err != nil {
+			continue
+		}
+		results = append(results, chainResult(c, rank))
+	}
+	return results, rows.Err()
+}
+
+func (p *Postgres) users(ctx context.Context, q Query) ([]models.SearchResult, error) {
+	rows, err := p.DB.QueryContext(ctx, `
+		SELECT id, username,
+			CASE WHEN lower(username) = lower($1) THEN 1 ELSE 0 END + similarity(username, $1) AS rank
+		FROM users
+		WHERE username ILIKE '%' || $1 || '%' OR username % $1
+		ORDER BY rank DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, q.Text, q.Limit, q.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var u models.User
+		var rank float64
+		if err := rows.Scan(&u.ID, &u.Username, &rank); err != nil {
+			continue
+		}
+		results = append(results, userResult(u, rank))
+	}
+	return results, rows.Err()
+}
+
+// songResult includes the song itself only if the caller has opened it.
+// Otherwise the crumb and platform are all there is, as on the pool topic.
+func songResult(s models.Song, opened bool, rank float64) models.SearchResult {
+	result := models.SearchResult{
+		Kind:     models.SearchKindSong,
+		ID:       s.ID,
+		Title:    s.Platform,
+		Subtitle: &s.Platform,
+		Rank:     rank,
+	}
+	if opened {
+		result.Title = s.URL
+		result.Song = &s
+	}
+	if s.ContextCrumb != nil && *s.ContextCrumb != "" {
+		result.Title = *s.ContextCrumb
+	}
+	return result
+}
+
+func chainResult(c models.Chain, rank float64) models.SearchResult {
+	return models.SearchResult{
+		Kind:     models.SearchKindChain,
+		ID:       c.ID,
+		Title:    c.Name,
+		Subtitle: c.Description,
+		Rank:     rank,
+		Chain:    &c,
+	}
+}
+
+func userResult(u models.User, rank float64) models.SearchResult {
+	return models.SearchResult{
+		Kind:  models.SearchKindUser,
+		ID:    u.ID,
+		Title: u.Username,
+		Rank:  rank,
+	}
+}
@@ -0,0 +1,29 @@
+package search
+
+import (
+	"context"
+
+	"github.com/halva/songswap/internal/models"
+)
+
+// Query describes a single search request. Limit and Offset apply to each
+// kind separately so every group can be paged on its own. UserID is the
+// signed-in caller, or 0: only songs they submitted or discovered come back
+// with their URL.
+type Query struct {
+	Text   string
+	Kinds  map[string]bool
+	Limit  int
+	Offset int
+	UserID int64
+}
+
+// wants reports whether results of the given kind were requested
+func (q Query) wants(kind string) bool {
+	return len(q.Kinds) == 0 || q.Kinds[kind]
+}
+
+// Store runs searches. Postgres is used in production, Memory in tests.
+type Store interface {
+	Search(ctx context.Context, q Query) (models.SearchResponse, error)
+}
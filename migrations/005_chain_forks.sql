@@ -0,0 +1,4 @@
+-- Chains can be forked from another chain
+ALTER TABLE chains ADD COLUMN forked_from INTEGER REFERENCES chains(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_chains_forked_from ON chains(forked_from);
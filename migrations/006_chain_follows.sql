@@ -0,0 +1,11 @@
+-- Users following chains; last_seen_at marks the last feed check
+CREATE TABLE chain_follows (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chain_id INTEGER NOT NULL REFERENCES chains(id) ON DELETE CASCADE,
+    followed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, chain_id)
+);
+
+CREATE INDEX idx_chain_follows_chain_id ON chain_follows(chain_id);
+CREATE INDEX idx_chain_songs_chain_id_added_at ON chain_songs(chain_id, added_at);
@@ -0,0 +1,14 @@
+-- Full-text search over songs, chains and users, with trigram fallback for typos
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_songs_search ON songs USING GIN (
+    to_tsvector('simple', coalesce(context_crumb, '') || ' ' || platform || ' ' || url)
+);
+CREATE INDEX idx_songs_crumb_trgm ON songs USING GIN (coalesce(context_crumb, '') gin_trgm_ops);
+
+CREATE INDEX idx_chains_search ON chains USING GIN (
+    to_tsvector('simple', name || ' ' || coalesce(description, ''))
+);
+CREATE INDEX idx_chains_name_trgm ON chains USING GIN (name gin_trgm_ops);
+
+CREATE INDEX idx_users_username_trgm ON users USING GIN (username gin_trgm_ops);
@@ -0,0 +1,14 @@
+-- Track when a song was liked so likes can decay in trending scores
+ALTER TABLE discoveries ADD COLUMN liked_at TIMESTAMP WITH TIME ZONE;
+UPDATE discoveries SET liked_at = discovered_at WHERE liked = true;
+
+-- Materialized chain ranking, refreshed periodically by the API's background job
+CREATE TABLE chain_scores (
+    chain_id INTEGER PRIMARY KEY REFERENCES chains(id) ON DELETE CASCADE,
+    trending_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+    like_count INTEGER NOT NULL DEFAULT 0,
+    computed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_chain_scores_trending ON chain_scores(trending_score DESC);
+CREATE INDEX idx_chain_scores_likes ON chain_scores(like_count DESC);
@@ -0,0 +1,4 @@
+-- Where and how each session was started, for the active sessions list
+ALTER TABLE sessions ADD COLUMN user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN ip VARCHAR(64);
+ALTER TABLE sessions ADD COLUMN login_method VARCHAR(20) NOT NULL DEFAULT 'password';
@@ -0,0 +1,10 @@
+-- Pending OAuth flows. A row is deleted when its callback arrives, so each state is single-use.
+CREATE TABLE oauth_states (
+    nonce VARCHAR(64) PRIMARY KEY,
+    provider VARCHAR(20) NOT NULL,
+    code_verifier VARCHAR(128),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_oauth_states_expires_at ON oauth_states(expires_at);
@@ -0,0 +1,5 @@
+-- OAuth flows started by a signed-in user link the provider to that user
+ALTER TABLE oauth_states ADD COLUMN link_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE;
+
+-- One account per provider per user
+ALTER TABLE linked_accounts ADD CONSTRAINT linked_accounts_user_id_provider_key UNIQUE (user_id, provider);
@@ -0,0 +1,2 @@
+-- OAuth signups get a generated username they can replace once on first login
+ALTER TABLE users ADD COLUMN needs_username BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,18 @@
+-- Optional email, used for password resets once verified
+ALTER TABLE users ADD COLUMN email VARCHAR(254);
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX idx_users_email ON users (LOWER(email));
+
+-- Single-use tokens mailed to users for password resets and email verification
+CREATE TABLE account_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL,
+    -- For verification tokens, the address being verified
+    email VARCHAR(254),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_account_tokens_user_id ON account_tokens(user_id);
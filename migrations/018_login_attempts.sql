@@ -0,0 +1,8 @@
+-- Failed login tracking for brute-force protection. key is "user:<username>"
+-- or "ip:<address>"; both are checked before a password is compared.
+CREATE TABLE login_attempts (
+    key VARCHAR(120) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,8 @@
+-- Public profile fields and privacy settings. Song lists are private unless opted in.
+ALTER TABLE users ADD COLUMN display_name VARCHAR(50);
+ALTER TABLE users ADD COLUMN bio VARCHAR(300);
+ALTER TABLE users ADD COLUMN avatar_url VARCHAR(500);
+ALTER TABLE users ADD COLUMN show_submissions BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN show_likes BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX idx_songs_submitted_by ON songs(submitted_by);
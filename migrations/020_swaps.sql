@@ -0,0 +1,25 @@
+-- Direct swaps: a song sent to one user, unlocked when they send one back
+CREATE TABLE swaps (
+    id SERIAL PRIMARY KEY,
+    sender_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    note VARCHAR(200),
+    reply_song_id INTEGER REFERENCES songs(id) ON DELETE SET NULL,
+    reply_note VARCHAR(200),
+    -- pending, completed or expired
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    CHECK (sender_id <> recipient_id)
+);
+
+CREATE INDEX idx_swaps_recipient ON swaps(recipient_id, created_at DESC);
+CREATE INDEX idx_swaps_sender ON swaps(sender_id, created_at DESC);
+
+-- Only one pending swap from a sender to a recipient at a time
+CREATE UNIQUE INDEX idx_swaps_pending_pair ON swaps(sender_id, recipient_id) WHERE status = 'pending';
+
+-- Completed swaps are delivered as discoveries so they show up in history
+ALTER TABLE discoveries ADD COLUMN swap_id INTEGER REFERENCES swaps(id) ON DELETE SET NULL;
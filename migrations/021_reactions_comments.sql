@@ -0,0 +1,32 @@
+-- Emoji reactions and comment threads on songs
+CREATE TABLE song_reactions (
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (song_id, user_id, emoji)
+);
+
+CREATE INDEX idx_song_reactions_user ON song_reactions(user_id);
+
+CREATE TABLE song_comments (
+    id SERIAL PRIMARY KEY,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body VARCHAR(500) NOT NULL,
+    -- visible, held (only its author sees it) or hidden
+    status VARCHAR(20) NOT NULL DEFAULT 'visible',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_song_comments_song ON song_comments(song_id, created_at);
+CREATE INDEX idx_song_comments_user ON song_comments(user_id);
+
+-- One report per user per comment; enough of them hide the comment
+CREATE TABLE comment_reports (
+    comment_id INTEGER NOT NULL REFERENCES song_comments(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason VARCHAR(200),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (comment_id, user_id)
+);
@@ -0,0 +1,21 @@
+-- Notifications for submitters about what happens to their songs
+CREATE TABLE notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- discovered, liked or added_to_chain
+    kind VARCHAR(20) NOT NULL,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    chain_id INTEGER REFERENCES chains(id) ON DELETE CASCADE,
+    -- how many times it happened while the notification was unread
+    count INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    read_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_notifications_user ON notifications(user_id, updated_at DESC);
+
+-- Repeats of an unread notification are folded into it rather than added
+CREATE UNIQUE INDEX idx_notifications_unread
+    ON notifications(user_id, kind, song_id, COALESCE(chain_id, 0))
+    WHERE read_at IS NULL;
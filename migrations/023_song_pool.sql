@@ -0,0 +1,4 @@
+-- Songs sent by URL in a swap or live match only go to the person they're sent
+-- to, so they're kept out of the pool: Discover and search skip them. Songs sent
+-- that way before this can't be told apart from submissions and stay in it.
+ALTER TABLE songs ADD COLUMN in_pool BOOLEAN NOT NULL DEFAULT TRUE;
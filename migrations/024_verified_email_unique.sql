@@ -0,0 +1,4 @@
+-- Only a verified address belongs to anyone. Counting unverified ones let an
+-- address be claimed, without proof, to keep its owner from adding it.
+DROP INDEX idx_users_email;
+CREATE UNIQUE INDEX idx_users_email ON users (LOWER(email)) WHERE email_verified_at IS NOT NULL;